@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Exponential backoff with jitter, so many forwarders retrying against the
+// same recovering server spread their attempts out rather than hitting it
+// in lockstep.
+type backoff struct {
+	min, max time.Duration
+	current  time.Duration
+}
+
+func newBackoff(config *NetworkConfig) *backoff {
+	return &backoff{min: config.reconnectBackoff, max: config.maxReconnectBackoff}
+}
+
+// Sleeps for a random time between half and all of the current delay, then
+// doubles the delay for next time, up to the maximum.
+func (b *backoff) Wait() {
+	if b.current < b.min {
+		b.current = b.min
+	}
+	half := int64(b.current / 2)
+	time.Sleep(time.Duration(half + rand.Int63n(half+1)))
+
+	b.current *= 2
+	if b.current > b.max {
+		b.current = b.max
+	}
+}
+
+func (b *backoff) Reset() {
+	b.current = b.min
+}
+
+// The network timeout, adapted to how long acknowledgements actually take.
+// Each timeout doubles it, up to the maximum, since if everything is slow
+// there's no point giving up early. Successful sends ease it back down
+// towards a few times the observed latency, but never below the base.
+type adaptiveTimeout struct {
+	base, max time.Duration
+	current   time.Duration
+	latency   time.Duration /* moving average of ack latency */
+}
+
+func newAdaptiveTimeout(config *NetworkConfig) *adaptiveTimeout {
+	return &adaptiveTimeout{base: config.timeout, max: config.maxTimeout, current: config.timeout}
+}
+
+func (t *adaptiveTimeout) Timeout() time.Duration {
+	return t.current
+}
+
+func (t *adaptiveTimeout) Success(latency time.Duration) {
+	if t.latency == 0 {
+		t.latency = latency
+	} else {
+		t.latency = (7*t.latency + latency) / 8
+	}
+
+	target := 4 * t.latency
+	if target < t.base {
+		target = t.base
+	}
+	if target > t.max {
+		target = t.max
+	}
+
+	if t.current > target {
+		// Ratchet down slowly, halving the distance each time
+		t.current = target + (t.current-target)/2
+	} else {
+		t.current = target
+	}
+}
+
+func (t *adaptiveTimeout) Failure() {
+	t.current *= 2
+	if t.current > t.max {
+		t.current = t.max
+	}
+}
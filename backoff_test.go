@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	retry := &backoff{min: time.Millisecond, max: 4 * time.Millisecond}
+
+	for _, expected := range []time.Duration{2, 4, 4} {
+		started := time.Now()
+		retry.Wait()
+		if waited := time.Since(started); waited < retry.current/4 {
+			t.Fatalf("Expected to wait at least half the previous delay, waited %v", waited)
+		}
+		if retry.current != expected*time.Millisecond {
+			t.Fatalf("Expected the delay to grow to %v, got %v", expected*time.Millisecond, retry.current)
+		}
+	}
+
+	retry.Reset()
+	if retry.current != time.Millisecond {
+		t.Fatalf("Expected a reset to go back to the minimum, got %v", retry.current)
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	timeout := &adaptiveTimeout{base: 10 * time.Second, max: 60 * time.Second, current: 10 * time.Second}
+
+	// Ratchets up on failures, to the maximum
+	timeout.Failure()
+	timeout.Failure()
+	if timeout.Timeout() != 40*time.Second {
+		t.Fatalf("Expected two failures to give 40s, got %v", timeout.Timeout())
+	}
+	timeout.Failure()
+	if timeout.Timeout() != 60*time.Second {
+		t.Fatalf("Expected the timeout to be capped at 60s, got %v", timeout.Timeout())
+	}
+
+	// Eases back down while acks are quick, never below the base
+	timeout.Success(time.Second)
+	if timeout.Timeout() != 35*time.Second {
+		t.Fatalf("Expected a success to halve the distance to the base, got %v", timeout.Timeout())
+	}
+	for i := 0; i < 20; i++ {
+		timeout.Success(time.Second)
+	}
+	if timeout.Timeout() > 10*time.Second+time.Millisecond {
+		t.Fatalf("Expected the timeout to return to the base, got %v", timeout.Timeout())
+	}
+
+	// Follows slow acks up
+	for i := 0; i < 50; i++ {
+		timeout.Success(5 * time.Second)
+	}
+	if timeout.Timeout() < 19*time.Second || timeout.Timeout() > 20*time.Second {
+		t.Fatalf("Expected the timeout to follow slow acks to ~20s, got %v", timeout.Timeout())
+	}
+}
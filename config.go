@@ -1,47 +1,206 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
-	"regexp"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
 )
 
 const configFileSizeLimit = 10 << 20
 
+// The output built from the top-level "network" and "output" sections, used
+// by files that don't choose any outputs.
+const defaultOutputName = "default"
+
 var defaultConfig = &struct {
-	netTimeout   int64
-	fileDeadtime string
+	netTimeout      int64
+	reconnectMin    int64
+	reconnectMax    int64
+	compression     string
+	compressLevel   int
+	fileDeadtime    string
+	closeInactive   string
+	syslogFacility  string
+	syslogSeverity  string
+	syslogAppName   string
+	stdoutCodec     string
+	fileMaxSize     int64
+	fileMaxFiles    int
+	timestampField  string
+	summaryInterval time.Duration
 }{
-	netTimeout:   15,
-	fileDeadtime: "24h",
+	netTimeout:      15,
+	reconnectMin:    1,
+	reconnectMax:    60,
+	compression:     "zlib",
+	compressLevel:   3,
+	fileDeadtime:    "24h",
+	closeInactive:   "5m",
+	syslogFacility:  "user",
+	syslogSeverity:  "info",
+	syslogAppName:   "logstash-forwarder",
+	stdoutCodec:     "json",
+	fileMaxSize:     100 << 20,
+	fileMaxFiles:    5,
+	timestampField:  "timestamp",
+	summaryInterval: time.Minute,
 }
 
 type Config struct {
-	Network NetworkConfig `json:network`
-	Files   []FileConfig  `json:files`
+	Network  NetworkConfig           `json:"network"`
+	Output   OutputConfig            `json:"output"`
+	Outputs  map[string]OutputConfig `json:"outputs"`
+	Fields   map[string]string       `json:"fields"`
+	Defaults FileConfig              `json:"defaults"`
+	Files    []FileConfig            `json:"files"`
+
+	// The hostname sent with events, or how to look it up: "os" (the
+	// default) or "fqdn"
+	Hostname       string `json:"hostname"`
+	HostnameLookup string `json:"hostname lookup"`
+
+	// The limit on lines from all files together
+	RateLimit RateLimitConfig `json:"rate limit"`
+
+	// The most files harvested at once, 0 for no limit
+	MaxOpenFiles int `json:"max open files"`
 }
 
 type NetworkConfig struct {
-	Servers        []string `json:servers`
-	SSLCertificate string   `json:"ssl certificate"`
-	SSLKey         string   `json:"ssl key"`
-	SSLCA          string   `json:"ssl ca"`
-	Timeout        int64    `json:timeout`
-	timeout        time.Duration
+	Servers             []string `json:"servers"`
+	SSLCertificate      string   `json:"ssl certificate"`
+	SSLKey              string   `json:"ssl key"`
+	SSLCA               string   `json:"ssl ca"`
+	Timeout             int64    `json:"timeout"`
+	MaxTimeout          int64    `json:"max timeout"`
+	ReconnectBackoff    int64    `json:"reconnect backoff"`
+	MaxReconnectBackoff int64    `json:"max reconnect backoff"`
+	Protocol            string   `json:"protocol"`
+	Transport           string   `json:"transport"`
+	Compression         string   `json:"compression"`
+	CompressionLevel    int      `json:"compression level"`
+	MaxPayloadBytes     int64    `json:"max payload bytes"` /* of data frames, before compression */
+	timeout             time.Duration
+	maxTimeout          time.Duration
+	reconnectBackoff    time.Duration
+	maxReconnectBackoff time.Duration
+	name                string /* of the output, for stats */
+}
+
+// Where spooled events are published. The "network" type (the default) ships
+// to the servers in Network; "stdout" and "file" write locally.
+type OutputConfig struct {
+	Type     string        `json:"type"`
+	Network  NetworkConfig `json:"network"`
+	Codec    string        `json:"codec"`
+	Path     string        `json:"path"`
+	MaxSize  int64         `json:"max size"`
+	MaxFiles int           `json:"max files"`
 }
 
 type FileConfig struct {
-	Paths    []string          `json:paths`
-	Fields   map[string]string `json:fields`
-	DeadTime string            `json:"dead time"`
-	deadtime time.Duration
+	Paths                []string                     `json:"paths"`
+	Input                string                       `json:"input"`
+	OnEOF                string                       `json:"on eof"`
+	Fields               map[string]string            `json:"fields"`
+	Type                 string                       `json:"type"`
+	Tags                 []string                     `json:"tags"`
+	Metadata             []string                     `json:"metadata"`
+	IncludeLines         []string                     `json:"include lines"`
+	ExcludeLines         []string                     `json:"exclude lines"`
+	Processors           []ProcessorConfig            `json:"processors"`
+	RateLimit            RateLimitConfig              `json:"rate limit"`
+	Priority             int                          `json:"priority"`
+	TimestampFormat      string                       `json:"timestamp format"`
+	TimestampPattern     string                       `json:"timestamp pattern"`
+	TimestampLayout      string                       `json:"timestamp layout"`
+	Timezone             string                       `json:"timezone"`
+	TimestampField       string                       `json:"timestamp field"`
+	DeadTime             string                       `json:"dead time"`
+	CloseInactive        string                       `json:"close inactive"`
+	CloseDeleted         string                       `json:"close deleted"`
+	FingerprintBytes     int64                        `json:"fingerprint bytes"`
+	StartPosition        string                       `json:"start position"`
+	RotatedStartPosition string                       `json:"rotated start position"`
+	Outputs              []string                     `json:"outputs"`
+	SyslogFacility       string                       `json:"syslog facility"`
+	SyslogSeverity       string                       `json:"syslog severity"`
+	SyslogAppName        string                       `json:"syslog app name"`
+	SyslogStructuredData map[string]map[string]string `json:"syslog structured data"`
+	deadtime             time.Duration
+	closeInactive        time.Duration  /* of a file rotated away or deleted */
+	closeDeleted         time.Duration  /* reading a deleted file before giving up, 0 for no limit */
+	startPosition        *startPosition /* in files found at startup */
+	rotatedStartPosition *startPosition /* in files found since */
+	syslog               syslogHeader
+	fields               map[string]string /* sent with every event */
+	timestamp            *timestampParser
+	filter               *lineFilter
+	processors           processorChain
+	limiter              *rateLimiter
+}
+
+// Lines and bytes a second, over which lines are held back ("block", the
+// default), sampled one in Sample ("sample") or dropped ("drop"). Sampled
+// and dropped lines are summed up in an event every SummaryInterval.
+type RateLimitConfig struct {
+	Lines           int64  `json:"lines"`
+	Bytes           int64  `json:"bytes"`
+	Policy          string `json:"policy"`
+	Sample          uint64 `json:"sample"`
+	SummaryInterval string `json:"summary interval"`
+}
+
+// A processing step run on each event of a file entry. "regex" sets the
+// named groups of Pattern, "kv" splits key=value pairs, and "csv" and "tsv"
+// map the values of a record onto Columns. Each parses the line, or Field if
+// given, and adds FailureTag to the event's tags if it can't. "mask"
+// replaces what its Detectors and Rules find in the line and every field.
+type ProcessorConfig struct {
+	Type           string           `json:"type"`
+	Field          string           `json:"field"`
+	FailureTag     string           `json:"failure tag"`
+	Pattern        string           `json:"pattern"`
+	FieldSeparator string           `json:"field separator"`
+	ValueSeparator string           `json:"value separator"`
+	Columns        []string         `json:"columns"`
+	Separator      string           `json:"separator"`
+	Detectors      []string         `json:"detectors"`
+	Rules          []MaskRuleConfig `json:"rules"`
+	Replacement    string           `json:"replacement"`
+	HashKey        string           `json:"hash key"`
+}
+
+type MaskRuleConfig struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// Parsers for config files by extension. Files with any other extension, or
+// none, are read as json.
+var configParsers = map[string]func([]byte) (*configNode, error){
+	".json": parseJSONConfig,
+	".yaml": parseYAMLConfig,
+	".yml":  parseYAMLConfig,
+	".toml": parseTOMLConfig,
 }
 
+// Extensions of files that are never configs, but often sit beside them:
+// backups left by editors and package managers, and documentation.
+var ignoredConfigExtensions = []string{
+	".bak", ".orig", ".old", ".swp", ".swo", ".tmp", ".rej",
+	".dpkg-old", ".dpkg-new", ".dpkg-dist", ".rpmsave", ".rpmnew",
+	".md", ".txt", ".rst",
+}
+
+// Returns the config file, or the config files in a directory in name order,
+// which is the order they are merged in.
+// Hidden files, editor backups and READMEs in a directory are skipped.
 func DiscoverConfigs(file_or_directory string) (files []string, err error) {
 	fi, err := os.Stat(file_or_directory)
 	if err != nil {
@@ -53,8 +212,11 @@ func DiscoverConfigs(file_or_directory string) (files []string, err error) {
 		if err != nil {
 			return nil, err
 		}
-		for _, filename := range entries {
-			files = append(files, path.Join(file_or_directory, filename.Name()))
+		for _, entry := range entries {
+			if entry.IsDir() || isIgnoredConfig(entry.Name()) {
+				continue
+			}
+			files = append(files, path.Join(file_or_directory, entry.Name()))
 		}
 	} else {
 		files = append(files, file_or_directory)
@@ -62,52 +224,99 @@ func DiscoverConfigs(file_or_directory string) (files []string, err error) {
 	return files, nil
 }
 
-// Append values to the 'to' config from the 'from' config, erroring
-// if a value would be overwritten by the merge.
-func MergeConfig(to *Config, from Config) (err error) {
+func isIgnoredConfig(name string) bool {
+	if strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") ||
+		strings.HasPrefix(name, "#") || strings.HasPrefix(strings.ToUpper(name), "README") {
+		return true
+	}
+	extension := strings.ToLower(filepath.Ext(name))
+	for _, ignored := range ignoredConfigExtensions {
+		if extension == ignored {
+			return true
+		}
+	}
+	return false
+}
 
-	to.Network.Servers = append(to.Network.Servers, from.Network.Servers...)
-	to.Files = append(to.Files, from.Files...)
+// Merges the 'from' config into the 'to' config, as when 'from' was read
+// after 'to'. Settings given in 'from' override those in 'to', including
+// lists such as servers, while maps such as fields and outputs are merged
+// key by key. File entries are appended. A zero value, empty string or
+// empty list counts as not given, so it can't override an earlier setting.
+func MergeConfig(to *Config, from Config) {
+	files := append(to.Files, from.Files...)
+	mergeValue(reflect.ValueOf(to).Elem(), reflect.ValueOf(from))
+	to.Files = files
+}
 
-	// TODO: Is there a better way to do this in Go?
-	if from.Network.SSLCertificate != "" {
-		if to.Network.SSLCertificate != "" {
-			return fmt.Errorf("SSLCertificate already defined as '%s' in previous config file", to.Network.SSLCertificate)
+// Sets every setting that is given in from, recursing into structs and
+// maps. Maps are copied rather than updated, so they may be shared.
+func mergeValue(to, from reflect.Value) {
+	switch from.Kind() {
+	case reflect.Struct:
+		for i := 0; i < from.NumField(); i++ {
+			if from.Type().Field(i).PkgPath == "" { // exported
+				mergeValue(to.Field(i), from.Field(i))
+			}
 		}
-		to.Network.SSLCertificate = from.Network.SSLCertificate
-	}
-	if from.Network.SSLKey != "" {
-		if to.Network.SSLKey != "" {
-			return fmt.Errorf("SSLKey already defined as '%s' in previous config file", to.Network.SSLKey)
+	case reflect.Map:
+		if from.Len() == 0 {
+			return
 		}
-		to.Network.SSLKey = from.Network.SSLKey
-	}
-	if from.Network.SSLCA != "" {
-		if to.Network.SSLCA != "" {
-			return fmt.Errorf("SSLCA already defined as '%s' in previous config file", to.Network.SSLCA)
+		merged := reflect.MakeMap(to.Type())
+		for _, key := range to.MapKeys() {
+			merged.SetMapIndex(key, to.MapIndex(key))
 		}
-		to.Network.SSLCA = from.Network.SSLCA
-	}
-	if from.Network.Timeout != 0 {
-		if to.Network.Timeout != 0 {
-			return fmt.Errorf("Timeout already defined as '%d' in previous config file", to.Network.Timeout)
+		for _, key := range from.MapKeys() {
+			value := from.MapIndex(key)
+			existing := merged.MapIndex(key)
+			if existing.IsValid() && (value.Kind() == reflect.Struct || value.Kind() == reflect.Map) {
+				combined := reflect.New(value.Type()).Elem()
+				combined.Set(existing)
+				mergeValue(combined, value)
+				value = combined
+			}
+			merged.SetMapIndex(key, value)
+		}
+		to.Set(merged)
+	case reflect.Slice:
+		if from.Len() > 0 {
+			to.Set(from)
+		}
+	default:
+		if from.Interface() != reflect.Zero(from.Type()).Interface() {
+			to.Set(from)
 		}
-		to.Network.Timeout = from.Network.Timeout
 	}
-	return nil
 }
 
 func LoadConfig(path string) (config Config, err error) {
+	data, _, config, err := readConfig(path)
+	if err != nil {
+		emit("Failed to load config file '%s': %s\n", path, err)
+		return
+	}
+	emit("%s\n", data)
+	return
+}
+
+// Reads and decodes a config file, returning its raw contents and the line
+// of each setting as well. The format is chosen by the file's extension. An
+// empty file gives an empty config.
+func readConfig(path string) (data []byte, positions map[string]int, config Config, err error) {
 	config_file, err := os.Open(path)
 	if err != nil {
-		emit("Failed to open config file '%s': %s\n", path, err)
 		return
 	}
+	defer config_file.Close()
 
-	fi, _ := config_file.Stat()
+	fi, err := config_file.Stat()
+	if err != nil {
+		return
+	}
 	if size := fi.Size(); size > (configFileSizeLimit) {
-		emit("config file (%q) size exceeds reasonable limit (%d) - aborting", path, size)
-		return // REVU: shouldn't this return an error, then?
+		err = fmt.Errorf("config file size (%d) exceeds reasonable limit (%d)", size, configFileSizeLimit)
+		return
 	}
 
 	if fi.Size() == 0 {
@@ -115,58 +324,261 @@ func LoadConfig(path string) (config Config, err error) {
 		return
 	}
 
-	buffer := make([]byte, fi.Size())
-	_, err = config_file.Read(buffer)
-	emit("%s\n", buffer)
-
-	buffer, err = StripComments(buffer)
+	data, err = ioutil.ReadAll(config_file)
 	if err != nil {
-		emit("Failed to strip comments from json: %s\n", err)
 		return
 	}
 
-	err = json.Unmarshal(buffer, &config)
+	parse, ok := configParsers[strings.ToLower(filepath.Ext(path))]
+	if !ok {
+		parse = parseJSONConfig
+	}
+	node, err := parse(data)
 	if err != nil {
-		emit("Failed unmarshalling json: %s\n", err)
 		return
 	}
+	positions = make(map[string]int)
+	err = decodeConfigNode(node, &config, positions)
+	return
+}
 
-	for k, _ := range config.Files {
-		if config.Files[k].DeadTime == "" {
-			config.Files[k].DeadTime = defaultConfig.fileDeadtime
+// Applies defaults to a merged config: the built-in ones, and the
+// "defaults" file settings to every file entry.
+func FinalizeConfig(config *Config) error {
+	finalizeOutputs(config)
+	for k := range config.Files {
+		if err := finalizeFileConfig(&config.Files[k], &config.Defaults, config.Fields); err != nil {
+			return err
 		}
-		config.Files[k].deadtime, err = time.ParseDuration(config.Files[k].DeadTime)
+	}
+	return nil
+}
+
+// Sets up the outputs, including the default one made from the top-level
+// "output" and "network" settings unless an output of its name is given.
+func finalizeOutputs(config *Config) {
+	finalizeNetworkConfig(&config.Network)
+	finalizeOutputConfig(&config.Output)
+
+	if config.Outputs == nil {
+		config.Outputs = make(map[string]OutputConfig)
+	}
+	if _, exists := config.Outputs[defaultOutputName]; !exists {
+		output := config.Output
+		output.Network = config.Network
+		config.Outputs[defaultOutputName] = output
+	}
+	for name, output := range config.Outputs {
+		output.Network.name = name
+		finalizeNetworkConfig(&output.Network)
+		finalizeOutputConfig(&output)
+		config.Outputs[name] = output
+	}
+}
+
+// Fills in the settings a file entry doesn't give from defaults. Fields and
+// the like are merged, with the file's own values winning. The fields sent
+// with its events are the global fields, overridden by the file's fields,
+// type and tags.
+func finalizeFileConfig(fileconfig *FileConfig, defaults *FileConfig, globals map[string]string) (err error) {
+	inherited := *defaults
+	inherited.Paths = nil
+	mergeValue(reflect.ValueOf(&inherited).Elem(), reflect.ValueOf(*fileconfig))
+	*fileconfig = inherited
+
+	if fileconfig.DeadTime == "" {
+		fileconfig.DeadTime = defaultConfig.fileDeadtime
+	}
+	fileconfig.deadtime, err = time.ParseDuration(fileconfig.DeadTime)
+	if err != nil {
+		return settingErrorf("dead time", "Failed to parse dead time duration '%s' for %v. Error was: %s", fileconfig.DeadTime, fileconfig.Paths, err)
+	}
+	if fileconfig.CloseInactive == "" {
+		fileconfig.CloseInactive = defaultConfig.closeInactive
+	}
+	fileconfig.closeInactive, err = time.ParseDuration(fileconfig.CloseInactive)
+	if err != nil {
+		return settingErrorf("close inactive", "Failed to parse close inactive duration '%s' for %v. Error was: %s", fileconfig.CloseInactive, fileconfig.Paths, err)
+	}
+	if fileconfig.CloseDeleted != "" {
+		fileconfig.closeDeleted, err = time.ParseDuration(fileconfig.CloseDeleted)
 		if err != nil {
-			emit("Failed to parse dead time duration '%s'. Error was: %s\n", config.Files[k].DeadTime, err)
-			return
+			return settingErrorf("close deleted", "Failed to parse close deleted duration '%s' for %v. Error was: %s", fileconfig.CloseDeleted, fileconfig.Paths, err)
 		}
 	}
+	// -tail starts files at the end, unless the files entry says otherwise
+	startDefault := "beginning"
+	if options.tailOnRotate {
+		startDefault = "end"
+	}
+	if fileconfig.StartPosition == "" {
+		fileconfig.StartPosition = startDefault
+	}
+	if fileconfig.RotatedStartPosition == "" {
+		fileconfig.RotatedStartPosition = startDefault
+	}
+	if fileconfig.startPosition, err = parseStartPosition(fileconfig.StartPosition); err != nil {
+		return settingErrorf("start position", "Invalid start position for %v: %s", fileconfig.Paths, err)
+	}
+	if fileconfig.rotatedStartPosition, err = parseStartPosition(fileconfig.RotatedStartPosition); err != nil {
+		return settingErrorf("rotated start position", "Invalid rotated start position for %v: %s", fileconfig.Paths, err)
+	}
 
-	return
+	fileconfig.syslog, err = newSyslogHeader(fileconfig)
+	if err != nil {
+		return settingErrorf(settingPath("", err), "Invalid syslog settings for %v: %s", fileconfig.Paths, err)
+	}
+	if err = checkMetadata(fileconfig.Metadata); err != nil {
+		return settingErrorf("metadata", "Invalid metadata for %v: %s", fileconfig.Paths, err)
+	}
+	fileconfig.timestamp, err = newTimestampParser(fileconfig)
+	if err != nil {
+		return settingErrorf(settingPath("", err), "Invalid timestamp settings for %v: %s", fileconfig.Paths, err)
+	}
+	fileconfig.filter, err = newLineFilter(fileconfig)
+	if err != nil {
+		return settingErrorf(settingPath("", err), "Invalid line filter for %v: %s", fileconfig.Paths, err)
+	}
+	fileconfig.processors, err = newProcessorChain(fileconfig.Processors)
+	if err != nil {
+		return settingErrorf("processors", "Invalid processors for %v: %s", fileconfig.Paths, err)
+	}
+	fileconfig.limiter, err = newRateLimiter(&fileconfig.RateLimit)
+	if err != nil {
+		return settingErrorf(settingPath("rate limit", err), "Invalid rate limit for %v: %s", fileconfig.Paths, err)
+	}
+
+	if err = checkInput(fileconfig); err != nil {
+		return settingErrorf(settingPath("", err), "Invalid input for %v: %s", fileconfig.Paths, err)
+	}
+	if fileconfig.Input == "" {
+		fileconfig.Input = "file"
+	}
+	if fileconfig.OnEOF == "" {
+		fileconfig.OnEOF = "stop"
+	}
+
+	if fileconfig.FingerprintBytes < 0 {
+		return settingErrorf("fingerprint bytes", "Invalid fingerprint bytes %d for %v, it must not be negative", fileconfig.FingerprintBytes, fileconfig.Paths)
+	}
+	if fileconfig.Priority < 0 {
+		return settingErrorf("priority", "Invalid priority %d for %v, it must be at least 1", fileconfig.Priority, fileconfig.Paths)
+	} else if fileconfig.Priority == 0 {
+		fileconfig.Priority = 1
+	}
+
+	if len(fileconfig.Outputs) == 0 {
+		fileconfig.Outputs = []string{defaultOutputName}
+	}
+
+	fileconfig.fields = make(map[string]string, len(globals)+len(fileconfig.Fields)+2)
+	for name, value := range globals {
+		fileconfig.fields[name] = value
+	}
+	for name, value := range fileconfig.Fields {
+		fileconfig.fields[name] = value
+	}
+	if fileconfig.Type != "" {
+		fileconfig.fields["type"] = fileconfig.Type
+	}
+	if len(fileconfig.Tags) > 0 {
+		fileconfig.fields["tags"] = strings.Join(fileconfig.Tags, ",")
+	}
+	return nil
 }
 
-func FinalizeConfig(config *Config) {
-	if config.Network.Timeout == 0 {
-		config.Network.Timeout = defaultConfig.netTimeout
+func finalizeNetworkConfig(network *NetworkConfig) {
+	if network.Timeout == 0 {
+		network.Timeout = defaultConfig.netTimeout
+	}
+	if network.MaxTimeout == 0 {
+		network.MaxTimeout = 4 * network.Timeout
+	}
+	if network.ReconnectBackoff == 0 {
+		network.ReconnectBackoff = defaultConfig.reconnectMin
+	}
+	if network.MaxReconnectBackoff == 0 {
+		network.MaxReconnectBackoff = defaultConfig.reconnectMax
+	}
+	if network.Protocol == "" {
+		network.Protocol = "lumberjack"
+	}
+	if network.Transport == "" {
+		network.Transport = "tls"
+	}
+	if network.Compression == "" {
+		network.Compression = defaultConfig.compression
+	}
+	if network.CompressionLevel == 0 {
+		network.CompressionLevel = defaultConfig.compressLevel
 	}
 
-	config.Network.timeout = time.Duration(config.Network.Timeout) * time.Second
+	network.timeout = time.Duration(network.Timeout) * time.Second
+	network.maxTimeout = time.Duration(network.MaxTimeout) * time.Second
+	network.reconnectBackoff = time.Duration(network.ReconnectBackoff) * time.Second
+	network.maxReconnectBackoff = time.Duration(network.MaxReconnectBackoff) * time.Second
 }
 
-func StripComments(data []byte) ([]byte, error) {
-	data = bytes.Replace(data, []byte("\r"), []byte(""), 0) // Windows
-	lines := bytes.Split(data, []byte("\n"))
-	filtered := make([][]byte, 0)
+func finalizeOutputConfig(output *OutputConfig) {
+	if output.Type == "" {
+		output.Type = "network"
+	}
+	if output.Codec == "" {
+		output.Codec = defaultConfig.stdoutCodec
+	}
+	if output.MaxSize == 0 {
+		output.MaxSize = defaultConfig.fileMaxSize
+	}
+	if output.MaxFiles == 0 {
+		output.MaxFiles = defaultConfig.fileMaxFiles
+	}
+}
 
-	for _, line := range lines {
-		match, err := regexp.Match(`^\s*#`, line)
-		if err != nil {
-			return nil, err
+// Checks the settings of a finalized output, so a bad one is reported before
+// any publisher is started.
+func CheckOutputConfig(output *OutputConfig) error {
+	switch output.Type {
+	case "network":
+		if len(output.Network.Servers) == 0 {
+			return settingErrorf("network.servers", "no servers given")
+		}
+		if output.Network.MaxTimeout < output.Network.Timeout {
+			return settingErrorf("network.max timeout", "max timeout (%d) is less than timeout (%d)", output.Network.MaxTimeout, output.Network.Timeout)
+		}
+		if output.Network.MaxReconnectBackoff < output.Network.ReconnectBackoff {
+			return settingErrorf("network.max reconnect backoff", "max reconnect backoff (%d) is less than reconnect backoff (%d)", output.Network.MaxReconnectBackoff, output.Network.ReconnectBackoff)
+		}
+		switch output.Network.Protocol {
+		case "lumberjack":
+			if output.Network.MaxPayloadBytes < 0 {
+				return settingErrorf("network.max payload bytes", "max payload bytes, counted before compression, must not be negative")
+			}
+			switch output.Network.Compression {
+			case "none", "lz4":
+			case "zlib":
+				if output.Network.CompressionLevel < 1 || output.Network.CompressionLevel > 9 {
+					return settingErrorf("network.compression level", "zlib compression level must be 1 to 9, got %d", output.Network.CompressionLevel)
+				}
+			default:
+				return settingErrorf("network.compression", "unknown compression '%s', expected 'none', 'zlib' or 'lz4'", output.Network.Compression)
+			}
+		case "syslog":
+			if output.Network.Transport != "tls" && output.Network.Transport != "tcp" {
+				return settingErrorf("network.transport", "unknown syslog transport '%s', expected 'tls' or 'tcp'", output.Network.Transport)
+			}
+		default:
+			return settingErrorf("network.protocol", "unknown network protocol '%s'", output.Network.Protocol)
 		}
-		if !match {
-			filtered = append(filtered, line)
+	case "stdout":
+		if output.Codec != "json" && output.Codec != "frames" {
+			return settingErrorf("codec", "unknown stdout codec '%s', expected 'json' or 'frames'", output.Codec)
 		}
+	case "file":
+		if output.Path == "" {
+			return settingErrorf("path", "the file output requires a path")
+		}
+	default:
+		return settingErrorf("type", "unknown output type '%s'", output.Type)
 	}
-
-	return bytes.Join(filtered, []byte("\n")), nil
+	return nil
 }
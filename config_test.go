@@ -56,6 +56,25 @@ func TestDiscoverConfigs(t *testing.T) {
 	}
 }
 
+func TestDiscoverConfigsSkipsUnrelatedFiles(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	for _, name := range []string{"b.yaml", "a.json", "c.toml", "a.json~", "a.json.bak", ".a.json.swp",
+		"#a.json#", "README", "README.md", "c.toml.dpkg-old", "notes.txt"} {
+		chkerr(t, ioutil.WriteFile(path.Join(tmpdir, name), []byte{}, 0644))
+	}
+	chkerr(t, os.Mkdir(path.Join(tmpdir, "conf.d"), 0755))
+
+	configs, err := DiscoverConfigs(tmpdir)
+	chkerr(t, err)
+
+	expected := []string{path.Join(tmpdir, "a.json"), path.Join(tmpdir, "b.yaml"), path.Join(tmpdir, "c.toml")}
+	if !reflect.DeepEqual(configs, expected) {
+		t.Fatalf("Expected to find %v, got %v instead", expected, configs)
+	}
+}
+
 func TestLoadEmptyConfig(t *testing.T) {
 	tmpdir := makeTempDir(t)
 	defer rmTempDir(tmpdir)
@@ -114,7 +133,6 @@ func TestLoadConfigAndStripComments(t *testing.T) {
 		t.Fatalf("Error loading config file: %s", err)
 	}
 
-	defaultDeadTime, _ := time.ParseDuration(defaultConfig.fileDeadtime)
 	expected := Config{
 		Network: NetworkConfig{
 			Servers:        []string{"localhost:5043"},
@@ -127,12 +145,9 @@ func TestLoadConfigAndStripComments(t *testing.T) {
 			Paths:    []string{"/var/log/*.log", "/var/log/messages"},
 			Fields:   map[string]string{"type": "syslog"},
 			DeadTime: "6h",
-			deadtime: 21600000000000,
 		}, {
-			Paths:    []string{"/var/log/apache2/access.log"},
-			Fields:   map[string]string{"type": "apache"},
-			DeadTime: defaultConfig.fileDeadtime,
-			deadtime: defaultDeadTime,
+			Paths:  []string{"/var/log/apache2/access.log"},
+			Fields: map[string]string{"type": "apache"},
 		}},
 	}
 
@@ -140,6 +155,49 @@ func TestLoadConfigAndStripComments(t *testing.T) {
 		t.Fatalf("Expected\n%v\n\ngot\n\n%v\n\nfrom LoadConfig", expected, config)
 	}
 
+	// File settings are defaulted once every config file has been merged
+	chkerr(t, FinalizeConfig(&config))
+	defaultDeadTime, _ := time.ParseDuration(defaultConfig.fileDeadtime)
+	defaultSyslog := syslogHeader{pri: 14, appName: defaultConfig.syslogAppName, structuredData: "-"}
+	expectedFiles := []FileConfig{{
+		Paths:                []string{"/var/log/*.log", "/var/log/messages"},
+		Fields:               map[string]string{"type": "syslog"},
+		DeadTime:             "6h",
+		Priority:             1,
+		Input:                "file",
+		OnEOF:                "stop",
+		CloseInactive:        defaultConfig.closeInactive,
+		StartPosition:        "beginning",
+		RotatedStartPosition: "beginning",
+		Outputs:              []string{defaultOutputName},
+		deadtime:             21600000000000,
+		syslog:               defaultSyslog,
+		closeInactive:        5 * time.Minute,
+		startPosition:        &startPosition{name: "beginning"},
+		rotatedStartPosition: &startPosition{name: "beginning"},
+		fields:               map[string]string{"type": "syslog"},
+	}, {
+		Paths:                []string{"/var/log/apache2/access.log"},
+		Fields:               map[string]string{"type": "apache"},
+		DeadTime:             defaultConfig.fileDeadtime,
+		Priority:             1,
+		Input:                "file",
+		OnEOF:                "stop",
+		CloseInactive:        defaultConfig.closeInactive,
+		StartPosition:        "beginning",
+		RotatedStartPosition: "beginning",
+		Outputs:              []string{defaultOutputName},
+		deadtime:             defaultDeadTime,
+		syslog:               defaultSyslog,
+		closeInactive:        5 * time.Minute,
+		startPosition:        &startPosition{name: "beginning"},
+		rotatedStartPosition: &startPosition{name: "beginning"},
+		fields:               map[string]string{"type": "apache"},
+	}}
+
+	if !reflect.DeepEqual(config.Files, expectedFiles) {
+		t.Fatalf("Expected\n%v\n\ngot\n\n%v\n\nfrom FinalizeConfig", expectedFiles, config.Files)
+	}
 }
 
 func TestFinalizeConfig(t *testing.T) {
@@ -158,6 +216,68 @@ func TestFinalizeConfig(t *testing.T) {
 	}
 }
 
+func TestFinalizeConfigOutputs(t *testing.T) {
+	config := Config{
+		Network: NetworkConfig{Servers: []string{"localhost:5043"}},
+		Outputs: map[string]OutputConfig{
+			"debug": {Type: "stdout"},
+		},
+		Files: []FileConfig{{
+			Paths: []string{"/var/log/messages"},
+		}, {
+			Paths:   []string{"/var/log/debug.log"},
+			Outputs: []string{"debug", "default"},
+		}},
+	}
+
+	FinalizeConfig(&config)
+
+	output := config.Outputs[defaultOutputName]
+	if output.Type != "network" || !reflect.DeepEqual(output.Network.Servers, config.Network.Servers) {
+		t.Fatalf("Expected the default output to use the network section, got %v", output)
+	}
+	chkerr(t, CheckOutputConfig(&output))
+
+	output = config.Outputs["debug"]
+	if output.Codec != defaultConfig.stdoutCodec {
+		t.Fatalf("Expected the debug output to default its codec, got %v", output)
+	}
+	if !reflect.DeepEqual(config.Files[0].Outputs, []string{defaultOutputName}) {
+		t.Fatalf("Expected files to route to the default output, got %v", config.Files[0].Outputs)
+	}
+	if !reflect.DeepEqual(config.Files[1].Outputs, []string{"debug", "default"}) {
+		t.Fatalf("Expected explicit routes to be kept, got %v", config.Files[1].Outputs)
+	}
+
+	if err := CheckOutputConfig(&OutputConfig{Type: "file"}); err == nil {
+		t.Fatalf("Expected a file output without a path to be invalid")
+	}
+}
+
+func TestFinalizeConfigFields(t *testing.T) {
+	config := Config{
+		Fields: map[string]string{"datacenter": "ams1", "env": "prod", "type": "generic"},
+		Files: []FileConfig{{
+			Paths: []string{"/var/log/messages"},
+		}, {
+			Paths:  []string{"/var/log/nginx/access.log"},
+			Fields: map[string]string{"env": "staging", "type": "ignored"},
+			Type:   "nginx",
+			Tags:   []string{"web", "frontend"},
+		}},
+	}
+	chkerr(t, FinalizeConfig(&config))
+
+	expected := map[string]string{"datacenter": "ams1", "env": "prod", "type": "generic"}
+	if !reflect.DeepEqual(config.Files[0].fields, expected) {
+		t.Fatalf("Expected the global fields %v, got %v", expected, config.Files[0].fields)
+	}
+	expected = map[string]string{"datacenter": "ams1", "env": "staging", "type": "nginx", "tags": "web,frontend"}
+	if !reflect.DeepEqual(config.Files[1].fields, expected) {
+		t.Fatalf("Expected file settings to override the global fields, got %v", config.Files[1].fields)
+	}
+}
+
 func TestMergeConfig(t *testing.T) {
 	configA := Config{
 		Network: NetworkConfig{
@@ -183,7 +303,7 @@ func TestMergeConfig(t *testing.T) {
 
 	expected := Config{
 		Network: NetworkConfig{
-			Servers:        []string{"localhost:5043", "otherhost:5043"},
+			Servers:        []string{"otherhost:5043"},
 			SSLCertificate: "./logstash-forwarder.crt",
 			SSLKey:         "./logstash-forwarder.key",
 			SSLCA:          "./logstash-forwarder.crt",
@@ -196,15 +316,428 @@ func TestMergeConfig(t *testing.T) {
 		}},
 	}
 
-	err := MergeConfig(&configA, configB)
-	chkerr(t, err)
+	MergeConfig(&configA, configB)
 
 	if !reflect.DeepEqual(configA, expected) {
 		t.Fatalf("Expected merged config to be %v, got %v instead", expected, configA)
 	}
 
-	err = MergeConfig(&configA, configB)
-	if err == nil {
-		t.Fatalf("Expected a double merge attempt to give us an error, it didn't")
+	// A later config overrides settings rather than conflicting
+	configC := Config{
+		Network: NetworkConfig{SSLCA: "./other.crt", Timeout: 30},
+	}
+	MergeConfig(&configA, configC)
+	if configA.Network.SSLCA != "./other.crt" || configA.Network.Timeout != 30 || configA.Network.SSLKey != "./logstash-forwarder.key" {
+		t.Fatalf("Expected later settings to override earlier ones, got %v", configA.Network)
+	}
+}
+
+func TestMergeConfigDefaults(t *testing.T) {
+	base := Config{
+		Outputs: map[string]OutputConfig{
+			"debug": {Type: "stdout", Codec: "frames"},
+		},
+		Defaults: FileConfig{
+			DeadTime: "1h",
+			Fields:   map[string]string{"env": "prod", "team": "ops"},
+		},
+	}
+	dropin := Config{
+		Outputs: map[string]OutputConfig{
+			"debug": {Codec: "json"},
+		},
+		Defaults: FileConfig{
+			Fields: map[string]string{"team": "web"},
+		},
+		Files: []FileConfig{{
+			Paths: []string{"/var/log/nginx/access.log"},
+		}, {
+			Paths:    []string{"/var/log/nginx/error.log"},
+			DeadTime: "6h",
+			Fields:   map[string]string{"env": "staging", "type": "nginx-error"},
+		}},
+	}
+
+	var config Config
+	MergeConfig(&config, base)
+	MergeConfig(&config, dropin)
+	chkerr(t, FinalizeConfig(&config))
+
+	if output := config.Outputs["debug"]; output.Type != "stdout" || output.Codec != "json" {
+		t.Fatalf("Expected outputs to be merged setting by setting, got %v", output)
+	}
+	if !reflect.DeepEqual(base.Defaults.Fields, map[string]string{"env": "prod", "team": "ops"}) {
+		t.Fatalf("Expected merging not to modify the merged config, got %v", base.Defaults.Fields)
+	}
+
+	access, errors := config.Files[0], config.Files[1]
+	if access.deadtime != time.Hour || !reflect.DeepEqual(access.Fields, map[string]string{"env": "prod", "team": "web"}) {
+		t.Fatalf("Expected a file to inherit the defaults, got %v", access)
+	}
+	if errors.deadtime != 6*time.Hour || !reflect.DeepEqual(errors.Fields, map[string]string{"env": "staging", "team": "web", "type": "nginx-error"}) {
+		t.Fatalf("Expected a file's own settings to win over the defaults, got %v", errors)
+	}
+	if !reflect.DeepEqual(config.Defaults.Fields, map[string]string{"env": "prod", "team": "web"}) {
+		t.Fatalf("Expected the defaults to be unchanged by files, got %v", config.Defaults.Fields)
+	}
+
+	config.Files[0].DeadTime = "forever"
+	if err := FinalizeConfig(&config); err == nil {
+		t.Fatalf("Expected an invalid dead time to be an error")
+	}
+}
+
+func TestReadConfigStrict(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	tests := []struct {
+		config   string
+		expected string
+	}{
+		{
+			"{\n  \"files\": [\n    { \"paths\": [ \"/var/log/messages\" ], \"dead_time\": \"6h\" }\n  ]\n}",
+			`3:41: unknown key "dead_time" in 'files[0]' (did you mean "dead time"?)`,
+		},
+		{
+			"{\n  \"network\": {\n    \"servers\": [ \"localhost:5043\" ],\n    \"ssl_ca\": \"./ca.crt\"\n  }\n}",
+			`4:5: unknown key "ssl_ca" in 'network' (did you mean "ssl ca"?)`,
+		},
+		{
+			"{\n  \"netwerk\": {}\n}",
+			`2:3: unknown key "netwerk" in the config (did you mean "network"?)`,
+		},
+		{
+			"{\n  \"bogus\": {}\n}",
+			`2:3: unknown key "bogus" in the config`,
+		},
+		{
+			"{\n  # \"network\": 1,\n  \"network\": {\n    \"timeout\": \"15\"\n  }\n}",
+			`4:16: expected a number for 'network.timeout', got a string`,
+		},
+		{
+			"{\n  \"network\": {\n    \"timeout\": 1.5\n  }\n}",
+			`3:16: expected a whole number for 'network.timeout', got 1.5`,
+		},
+		{
+			"{\n  \"files\": [\n    { \"paths\": [ \"/var/log/messages\" ] }\n    { \"paths\": [] }\n  ]\n}",
+			`4:5: expected ']', got '{'`,
+		},
+		{
+			"{\n  \"network\": { \"timeout\": 15 },\n  \"network\": { \"timeout\": 20 }\n}",
+			`3:3: duplicate key "network" in the config`,
+		},
+	}
+
+	configFile := path.Join(tmpdir, "myconfig")
+	for _, test := range tests {
+		chkerr(t, ioutil.WriteFile(configFile, []byte(test.config), 0644))
+		_, _, _, err := readConfig(configFile)
+		if err == nil {
+			t.Errorf("Expected an error reading %q", test.config)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("Expected error %q, got %q", test.expected, err)
+		}
+	}
+}
+
+func TestParseJSONConfigStrings(t *testing.T) {
+	node, err := parseJSONConfig([]byte(`{"fields": {"a": "tab\there", "b": "é😀", "c": "q\"\\/"}}`))
+	chkerr(t, err)
+
+	var config FileConfig
+	chkerr(t, decodeConfigNode(node, &config, nil))
+	expected := map[string]string{"a": "tab\there", "b": "é\U0001F600", "c": `q"\/`}
+	if !reflect.DeepEqual(config.Fields, expected) {
+		t.Fatalf("Expected fields %q, got %q", expected, config.Fields)
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	formats := map[string]string{
+		"config.json": `{
+  "network": {
+    "servers": [ "localhost:5043", ], # trailing commas and inline comments
+    "ssl ca": "./logstash-forwarder.ca",
+    "timeout": 20
+  },
+  "files": [
+    { "paths": [ "/var/log/*.log" ], "fields": { "type": "syslog", "version": "1.0" }, "dead time": "6h" },
+    { "paths": [ "/var/log/apache2/access.log" ], "fields": { "type": "apache #1" } },
+  ]
+}`,
+		"config.yaml": `
+---
+# The network section
+network:
+  servers: [ localhost:5043 ]
+  ssl ca: ./logstash-forwarder.ca   # inline comment
+  timeout: 20
+
+files:
+- paths:
+    - /var/log/*.log
+  fields: { type: syslog, version: 1.0 }
+  dead time: 6h
+- paths: [ "/var/log/apache2/access.log" ]
+  fields:
+    type: 'apache #1'
+`,
+		"config.toml": `
+# The network section
+[network]
+servers = [ "localhost:5043" ]
+"ssl ca" = './logstash-forwarder.ca'  # inline comment
+timeout = 20
+
+[[files]]
+paths = [
+  "/var/log/*.log",  # arrays may span lines
+]
+fields = { type = "syslog", version = "1.0" }
+"dead time" = "6h"
+
+[[files]]
+paths = [ "/var/log/apache2/access.log" ]
+fields.type = "apache #1"
+`,
+	}
+
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	var expected Config
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		configFile := path.Join(tmpdir, name)
+		chkerr(t, ioutil.WriteFile(configFile, []byte(formats[name]), 0644))
+
+		config, err := LoadConfig(configFile)
+		if err != nil {
+			t.Fatalf("Error loading %s: %s", name, err)
+		}
+		if name == "config.json" {
+			expected = config
+			if config.Files[0].Fields["version"] != "1.0" || config.Files[1].Fields["type"] != "apache #1" || config.Network.Timeout != 20 {
+				t.Fatalf("Unexpected config from %s: %v", name, config)
+			}
+		} else if !reflect.DeepEqual(config, expected) {
+			t.Fatalf("Expected %s to give\n%v\n\ngot\n\n%v", name, expected, config)
+		}
+	}
+}
+
+func TestParseYAMLConfig(t *testing.T) {
+	node, err := parseYAMLConfig([]byte(`
+fields:
+  literal: |
+    line one
+      indented
+    line three
+
+  folded: >-
+    folded
+    text
+
+    new paragraph
+  quoted: "tab\there # not a comment"
+  single: 'it''s'
+  url: http://example.com:80/path
+  empty: ""
+`))
+	chkerr(t, err)
+
+	var config FileConfig
+	chkerr(t, decodeConfigNode(node, &config, nil))
+	expected := map[string]string{
+		"literal": "line one\n  indented\nline three\n",
+		"folded":  "folded text\nnew paragraph",
+		"quoted":  "tab\there # not a comment",
+		"single":  "it's",
+		"url":     "http://example.com:80/path",
+		"empty":   "",
+	}
+	if !reflect.DeepEqual(config.Fields, expected) {
+		t.Fatalf("Expected fields %q, got %q", expected, config.Fields)
+	}
+
+	for _, test := range []struct {
+		source, expected string
+	}{
+		{"paths:\n  - a\n   - b\n", "3:4: unexpected indentation"},
+		{"paths:\n\t- a\n", "2:1: tabs are not allowed for indentation"},
+		{"paths: [ a, b\n", "1:8: unterminated flow collection"},
+		{"dead time: 6h\ndead_time: 1h\n", `2:1: unknown key "dead_time" in the config (did you mean "dead time"?)`},
+
+		// Anchors, aliases and tags
+		{"paths: *anchor\n", "1:8: YAML anchors, aliases and tags are not supported; quote the value if it is a string"},
+		{"fields: &base\n  a: b\n", "1:9: YAML anchors, aliases and tags are not supported; quote the value if it is a string"},
+		{"<<: *base\n", "1:5: YAML anchors, aliases and tags are not supported; quote the value if it is a string"},
+		{"paths: [ *a ]\n", "1:10: YAML anchors, aliases and tags are not supported; quote the value if it is a string"},
+		{"dead time: !!str 1h\n", "1:12: YAML anchors, aliases and tags are not supported; quote the value if it is a string"},
+		{"fields: { a: !local b }\n", "1:14: YAML anchors, aliases and tags are not supported; quote the value if it is a string"},
+
+		// Documents, directives and complex keys
+		{"dead time: 1h\n---\ndead time: 2h\n", "2:1: multiple YAML documents are not supported; use a config file for each"},
+		{"---\ndead time: 1h\n--- # next\n", "3:1: multiple YAML documents are not supported; use a config file for each"},
+		{"dead time: 1h\n...\ndead time: 2h\n", "3:1: multiple YAML documents are not supported; use a config file for each"},
+		{"%YAML 1.2\n---\ndead time: 1h\n", "1:1: YAML directives are not supported"},
+		{"? dead time\n: 1h\n", "1:1: YAML complex keys are not supported"},
+
+		// Quoting and escapes
+		{"dead time: \"1h\n", "1:15: unterminated string"},
+		{"dead time: '1h\n", "1:12: unterminated string"},
+		{"dead time: \"\\q\"\n", "1:14: invalid escape '\\q' in string"},
+		{"dead time: \"1h\" later\n", "1:16: unexpected content after a quoted string"},
+		{"dead time: 'it''s' 1h\n", "1:19: unexpected content after a quoted string"},
+		{"fields: { a: \"b }\n", "1:18: unterminated string"},
+		{"fields:\n  a: b: c\n", "2:7: unexpected ': ' in a value; quote the value if it is a string"},
+		{"dead time: |2\n  1h\n", "1:13: unsupported block scalar indicator '2'"},
+	} {
+		node, err := parseYAMLConfig([]byte(test.source))
+		if err == nil {
+			err = decodeConfigNode(node, &FileConfig{}, nil)
+		}
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected error %q parsing %q, got %v", test.expected, test.source, err)
+		}
+	}
+
+	// A single document may be started and ended
+	for _, source := range []string{"--- # config\ndead time: 1h\n", "dead time: 1h\n...\n\n"} {
+		node, err := parseYAMLConfig([]byte(source))
+		chkerr(t, err)
+		var config FileConfig
+		chkerr(t, decodeConfigNode(node, &config, nil))
+		if config.DeadTime != "1h" {
+			t.Errorf("Expected a dead time of 1h from %q, got %q", source, config.DeadTime)
+		}
+	}
+}
+
+func TestParseTOMLConfigErrors(t *testing.T) {
+	for _, test := range []struct {
+		source, expected string
+	}{
+		{"[network]\ntimeout = 1\n[network]\n", "3:2: table [network] defined twice"},
+		{"timeout = 1\ntimeout = 2\n", `2:1: key "timeout" defined twice`},
+		{"[[files]]\npaths = [ \"a\" ] junk\n", "2:17: expected the end of the line, got 'j'"},
+
+		// Dates and times
+		{"[[files]]\n\"dead time\" = 1979-05-27\n", "2:15: dates and times are not supported; quote the value if it is a string"},
+		{"[[files]]\n\"dead time\" = 1979-05-27T07:32:00Z\n", "2:15: dates and times are not supported; quote the value if it is a string"},
+		{"[[files]]\n\"dead time\" = 07:32:00\n", "2:15: dates and times are not supported; quote the value if it is a string"},
+
+		// Dotted keys
+		{"fields.a = \"b\"\nfields.a = \"c\"\n", `2:8: key "fields.a" defined twice`},
+		{"timeout = 1\ntimeout.a = 2\n", `2:1: key "timeout" is not a table`},
+		{"[fields]\na = \"b\"\n[[fields]]\n", `3:3: key "fields" is not an array of tables`},
+
+		// Inline tables and arrays, which are complete as given
+		{"fields = { a = \"b\", }\n", "1:21: trailing commas are not allowed in inline tables"},
+		{"fields = { a = \"b\"\n}\n", "1:19: inline tables must be on one line"},
+		{"fields = { a = \"b\" }\nfields.c = \"d\"\n", `2:1: key "fields" was given inline and can't be added to`},
+		{"fields = { a = \"b\" }\n[fields]\n", `2:2: key "fields" was given inline and can't be added to`},
+		{"files = [ { paths = [ \"a\" ] } ]\n[[files]]\n", `2:3: array "files" was given inline and can't be added to`},
+
+		// Quoting and escapes
+		{"dead time = \"1h\"\n", `1:6: expected '=' after key "dead"; keys with spaces must be quoted`},
+		{"\"dead time\" = \"1h\n", "1:18: unterminated string"},
+		{"\"dead time\" = '1h\n", "1:18: unterminated string"},
+		{"\"dead time\" = \"\"\"1h\n", "2:1: unterminated string"},
+		{"\"dead time\" = \"\\q\"\n", "1:17: invalid escape '\\q' in string"},
+		{"\"dead time\" = \"\\U0001F600\"\n", "1:17: invalid escape '\\U' in string"},
+	} {
+		_, err := parseTOMLConfig([]byte(test.source))
+		if err == nil || err.Error() != test.expected {
+			t.Errorf("Expected error %q parsing %q, got %v", test.expected, test.source, err)
+		}
+	}
+}
+
+func TestParseTOMLConfigStrings(t *testing.T) {
+	node, err := parseTOMLConfig([]byte(`
+[fields]
+basic = "tab\there # not a comment"
+literal = 'C:\Users\logs'
+unicode = "caf\u00e9"
+multiline = """
+first \
+  second"""
+"quoted key" = "1"
+'literal key' = "2"
+"escaped\u0020key" = "3"
+`))
+	chkerr(t, err)
+
+	var config FileConfig
+	chkerr(t, decodeConfigNode(node, &config, nil))
+	expected := map[string]string{
+		"basic":       "tab\there # not a comment",
+		"literal":     `C:\Users\logs`,
+		"unicode":     "caf\u00e9",
+		"multiline":   "first second",
+		"quoted key":  "1",
+		"literal key": "2",
+		"escaped key": "3",
+	}
+	if !reflect.DeepEqual(config.Fields, expected) {
+		t.Fatalf("Expected fields %q, got %q", expected, config.Fields)
+	}
+}
+
+func TestExpandConfigString(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	secret := path.Join(tmpdir, "secret")
+	chkerr(t, ioutil.WriteFile(secret, []byte("hunter2\n"), 0600))
+
+	os.Setenv("LSF_TEST_HOST", "logs.example.com")
+	os.Setenv("LSF_TEST_EMPTY", "")
+	defer os.Unsetenv("LSF_TEST_HOST")
+	defer os.Unsetenv("LSF_TEST_EMPTY")
+
+	for text, expected := range map[string]string{
+		"${LSF_TEST_HOST}:5043":           "logs.example.com:5043",
+		"${LSF_TEST_UNSET:-localhost}":    "localhost",
+		"${LSF_TEST_EMPTY:-localhost}":    "localhost",
+		"${LSF_TEST_EMPTY}":               "",
+		"password=${file:" + secret + "}": "password=hunter2",
+		"$${LSF_TEST_HOST} costs $5":      "${LSF_TEST_HOST} costs $5",
+		"/var/log/*.log":                  "/var/log/*.log",
+	} {
+		expanded, err := expandConfigString(text)
+		chkerr(t, err)
+		if expanded != expected {
+			t.Errorf("Expected %q to expand to %q, got %q", text, expected, expanded)
+		}
+	}
+
+	for _, text := range []string{"${LSF_TEST_UNSET}", "${LSF_TEST_HOST", "${not a name}", "${file:" + tmpdir + "/missing}"} {
+		if _, err := expandConfigString(text); err == nil {
+			t.Errorf("Expected an error expanding %q", text)
+		}
+	}
+}
+
+func TestDecodeConfigExpandsReferences(t *testing.T) {
+	os.Setenv("LSF_TEST_TIMEOUT", "30")
+	defer os.Unsetenv("LSF_TEST_TIMEOUT")
+
+	node, err := parseJSONConfig([]byte(`{
+  "network": { "servers": [ "${LSF_TEST_SERVER:-localhost:5043}" ], "timeout": "${LSF_TEST_TIMEOUT}" },
+  "files": [ { "paths": [ "${LSF_TEST_UNSET}" ] } ]
+}`))
+	chkerr(t, err)
+
+	var config Config
+	err = decodeConfigNode(node, &config, nil)
+	expected := `3:27: environment variable 'LSF_TEST_UNSET' is not set in 'files[0].paths[0]'`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("Expected error %q, got %v", expected, err)
+	}
+	if config.Network.Servers[0] != "localhost:5043" || config.Network.Timeout != 30 {
+		t.Fatalf("Expected the network section to be expanded, got %v", config.Network)
 	}
 }
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Config files are parsed into a tree of configNodes, which remember where
+// each value came from, and then decoded strictly onto the Config struct:
+// unknown keys and mismatched types are errors, reported with their line
+// and column.
+
+type nodeKind int
+
+const (
+	mapNode nodeKind = iota
+	listNode
+	stringNode
+	numberNode
+	boolNode
+	nullNode
+)
+
+var nodeKindNames = map[nodeKind]string{
+	mapNode:    "an object",
+	listNode:   "a list",
+	stringNode: "a string",
+	numberNode: "a number",
+	boolNode:   "a boolean",
+	nullNode:   "null",
+}
+
+type configNode struct {
+	kind         nodeKind
+	line, column int
+
+	entries []configEntry /* mapNode, in file order */
+	items   []*configNode /* listNode */
+	text    string        /* scalars: the string value, or the literal */
+	plain   bool          /* an unquoted YAML scalar, which may be read as a string */
+}
+
+type configEntry struct {
+	key          string
+	line, column int
+	value        *configNode
+}
+
+func (n *configNode) problem(msgfmt string, args ...interface{}) *ConfigProblem {
+	return &ConfigProblem{Line: n.line, Column: n.column, Message: fmt.Sprintf(msgfmt, args...)}
+}
+
+// Decodes node onto target, which must be a pointer. Struct fields are
+// matched by their json tag name. If positions isn't nil, the line of every
+// setting is recorded in it by path, such as "files[0].rate limit.lines".
+func decodeConfigNode(node *configNode, target interface{}, positions map[string]int) error {
+	return decodeValue(node, reflect.ValueOf(target).Elem(), "", positions)
+}
+
+func decodeValue(node *configNode, value reflect.Value, path string, positions map[string]int) error {
+	if positions != nil && path != "" {
+		positions[path] = node.line
+	}
+	if node.kind == nullNode {
+		return nil
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		if node.kind != mapNode {
+			return node.problem("expected an object for %s, got %s", describePath(path), nodeKindNames[node.kind])
+		}
+		fields := structFields(value.Type())
+		seen := make(map[string]bool)
+		for _, entry := range node.entries {
+			index, known := fields[entry.key]
+			if !known {
+				problem := &ConfigProblem{Line: entry.line, Column: entry.column}
+				problem.Message = fmt.Sprintf("unknown key %q in %s", entry.key, describePath(path))
+				if suggestion := suggestKey(entry.key, fields); suggestion != "" {
+					problem.Message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+				}
+				return problem
+			}
+			if seen[entry.key] {
+				return &ConfigProblem{Line: entry.line, Column: entry.column, Message: fmt.Sprintf("duplicate key %q in %s", entry.key, describePath(path))}
+			}
+			seen[entry.key] = true
+			if err := decodeValue(entry.value, value.Field(index), joinPath(path, entry.key), positions); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if node.kind != mapNode {
+			return node.problem("expected an object for %s, got %s", describePath(path), nodeKindNames[node.kind])
+		}
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(value.Type()))
+		}
+		for _, entry := range node.entries {
+			element := reflect.New(value.Type().Elem()).Elem()
+			if err := decodeValue(entry.value, element, joinPath(path, entry.key), positions); err != nil {
+				return err
+			}
+			value.SetMapIndex(reflect.ValueOf(entry.key), element)
+		}
+	case reflect.Slice:
+		if node.kind != listNode {
+			return node.problem("expected a list for %s, got %s", describePath(path), nodeKindNames[node.kind])
+		}
+		slice := reflect.MakeSlice(value.Type(), len(node.items), len(node.items))
+		for i, item := range node.items {
+			if err := decodeValue(item, slice.Index(i), fmt.Sprintf("%s[%d]", path, i), positions); err != nil {
+				return err
+			}
+		}
+		value.Set(slice)
+	case reflect.String:
+		if node.kind != stringNode && !(node.plain && node.kind != mapNode && node.kind != listNode) {
+			return node.problem("expected a string for %s, got %s", describePath(path), nodeKindNames[node.kind])
+		}
+		text, err := expandNode(node, path)
+		if err != nil {
+			return err
+		}
+		value.SetString(text)
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		text, err := scalarText(node, numberNode, path)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseInt(text, 10, value.Type().Bits())
+		if err != nil {
+			return node.problem("expected a whole number for %s, got %s", describePath(path), text)
+		}
+		value.SetInt(n)
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		text, err := scalarText(node, numberNode, path)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseUint(text, 10, value.Type().Bits())
+		if err != nil {
+			return node.problem("expected a positive whole number for %s, got %s", describePath(path), text)
+		}
+		value.SetUint(n)
+	case reflect.Bool:
+		text, err := scalarText(node, boolNode, path)
+		if err != nil {
+			return err
+		}
+		if text != "true" && text != "false" {
+			return node.problem("expected true or false for %s, got %s", describePath(path), text)
+		}
+		value.SetBool(text == "true")
+	default:
+		return node.problem("cannot decode %s into %s", describePath(path), value.Type())
+	}
+	return nil
+}
+
+// The text of a string node with any ${...} references expanded.
+func expandNode(node *configNode, path string) (string, error) {
+	text, err := expandConfigString(node.text)
+	if err != nil {
+		return "", node.problem("%s in %s", err, describePath(path))
+	}
+	return text, nil
+}
+
+// The text of a scalar of the given kind. A string with ${...} references
+// may stand in for one, so numbers and switches can come from the
+// environment too.
+func scalarText(node *configNode, kind nodeKind, path string) (string, error) {
+	if node.kind == kind {
+		return node.text, nil
+	}
+	if node.kind == stringNode && strings.Contains(node.text, "${") {
+		return expandNode(node, path)
+	}
+	if kind == boolNode {
+		return "", node.problem("expected true or false for %s, got %s", describePath(path), nodeKindNames[node.kind])
+	}
+	return "", node.problem("expected %s for %s, got %s", nodeKindNames[kind], describePath(path), nodeKindNames[node.kind])
+}
+
+// Maps the json tag names of t's exported fields to their index.
+func structFields(t reflect.Type) map[string]int {
+	fields := make(map[string]int)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = i
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "the config"
+	}
+	return "'" + path + "'"
+}
+
+// The known key most like key, if any is close enough to be a likely typo.
+// Underscores, dashes and case are ignored, so "dead_time" suggests
+// "dead time".
+func suggestKey(key string, fields map[string]int) string {
+	normalize := strings.NewReplacer("_", " ", "-", " ")
+	wanted := strings.ToLower(normalize.Replace(key))
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDistance := "", len(wanted)/3+1
+	for _, name := range names {
+		distance := editDistance(wanted, strings.ToLower(name))
+		if distance < bestDistance || (distance == 0 && best == "") {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+// Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
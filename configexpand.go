@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var configVariable_re = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Expands references in a config string value:
+//
+//	${VAR}            the environment variable VAR, which must be set
+//	${VAR:-default}   VAR, or default if it is unset or empty
+//	${file:/path}     the contents of a file, less any trailing newline
+//	$${               a literal "${"
+//
+// A "$" not followed by "{" is left alone.
+func expandConfigString(text string) (string, error) {
+	if !strings.Contains(text, "${") {
+		return text, nil
+	}
+
+	var expanded []byte
+	for i := 0; i < len(text); i++ {
+		if strings.HasPrefix(text[i:], "$${") {
+			expanded = append(expanded, "${"...)
+			i += 2
+			continue
+		}
+		if !strings.HasPrefix(text[i:], "${") {
+			expanded = append(expanded, text[i])
+			continue
+		}
+
+		end := strings.IndexByte(text[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference '%s'", text[i:])
+		}
+		value, err := resolveConfigReference(text[i+2 : i+end])
+		if err != nil {
+			return "", err
+		}
+		expanded = append(expanded, value...)
+		i += end
+	}
+	return string(expanded), nil
+}
+
+func resolveConfigReference(reference string) (string, error) {
+	if strings.HasPrefix(reference, "file:") {
+		filename := reference[len("file:"):]
+		contents, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("failed to read '${%s}': %s", reference, err)
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	}
+
+	name, fallback, hasFallback := reference, "", false
+	if i := strings.Index(reference, ":-"); i >= 0 {
+		name, fallback, hasFallback = reference[:i], reference[i+2:], true
+	}
+	if !configVariable_re.MatchString(name) {
+		return "", fmt.Errorf("invalid reference '${%s}'", reference)
+	}
+
+	value, set := lookupEnv(name)
+	if hasFallback && value == "" {
+		return fallback, nil
+	}
+	if !set {
+		return "", fmt.Errorf("environment variable '%s' is not set", name)
+	}
+	return value, nil
+}
+
+// Like os.Getenv, but tells an empty variable from an unset one.
+func lookupEnv(name string) (string, bool) {
+	for _, pair := range os.Environ() {
+		if strings.HasPrefix(pair, name+"=") {
+			return pair[len(name)+1:], true
+		}
+	}
+	return "", false
+}
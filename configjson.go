@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Parses a json config into a configNode tree. Being written by people
+// rather than programs, configs may have '#' comments, which run to the end
+// of the line, and trailing commas in lists and objects.
+func parseJSONConfig(data []byte) (*configNode, error) {
+	p := &jsonParser{data: data, line: 1, column: 1}
+	p.skipSpace()
+	node, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.data) {
+		return nil, p.problem("unexpected %s after the end of the config", p.describe())
+	}
+	return node, nil
+}
+
+type jsonParser struct {
+	data         []byte
+	pos          int
+	line, column int
+}
+
+func (p *jsonParser) problem(msgfmt string, args ...interface{}) *ConfigProblem {
+	return &ConfigProblem{Line: p.line, Column: p.column, Message: fmt.Sprintf(msgfmt, args...)}
+}
+
+// The next character, for error messages.
+func (p *jsonParser) describe() string {
+	if p.pos >= len(p.data) {
+		return "end of file"
+	}
+	r, _ := utf8.DecodeRune(p.data[p.pos:])
+	return strconv.QuoteRune(r)
+}
+
+func (p *jsonParser) advance() {
+	if p.data[p.pos] == '\n' {
+		p.line++
+		p.column = 1
+	} else if p.data[p.pos]&0xC0 != 0x80 { // count runes, not bytes
+		p.column++
+	}
+	p.pos++
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\r', '\n':
+			p.advance()
+		case '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.advance()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) expect(c byte) error {
+	p.skipSpace()
+	if p.pos >= len(p.data) || p.data[p.pos] != c {
+		return p.problem("expected '%c', got %s", c, p.describe())
+	}
+	p.advance()
+	return nil
+}
+
+func (p *jsonParser) value() (*configNode, error) {
+	if p.pos >= len(p.data) {
+		return nil, p.problem("unexpected end of file, expected a value")
+	}
+	node := &configNode{line: p.line, column: p.column}
+
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		node.kind = mapNode
+		return node, p.object(node)
+	case c == '[':
+		node.kind = listNode
+		return node, p.list(node)
+	case c == '"':
+		node.kind = stringNode
+		text, err := p.str()
+		node.text = text
+		return node, err
+	case c == '-' || (c >= '0' && c <= '9'):
+		node.kind = numberNode
+		node.text = p.number()
+	case p.literal("true") || p.literal("false"):
+		node.kind = boolNode
+		node.text = p.word()
+	case p.literal("null"):
+		node.kind = nullNode
+		node.text = p.word()
+	default:
+		return nil, p.problem("unexpected %s, expected a value", p.describe())
+	}
+	return node, nil
+}
+
+func (p *jsonParser) object(node *configNode) error {
+	p.advance() // '{'
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == '}' {
+		p.advance()
+		return nil
+	}
+
+	for {
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == '}' && len(node.entries) > 0 {
+			p.advance() // after a trailing comma
+			return nil
+		}
+		if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+			return p.problem("expected a quoted key, got %s", p.describe())
+		}
+		entry := configEntry{line: p.line, column: p.column}
+		key, err := p.str()
+		if err != nil {
+			return err
+		}
+		entry.key = key
+
+		if err := p.expect(':'); err != nil {
+			return err
+		}
+		p.skipSpace()
+		if entry.value, err = p.value(); err != nil {
+			return err
+		}
+		node.entries = append(node.entries, entry)
+
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.advance()
+			continue
+		}
+		return p.expect('}')
+	}
+}
+
+func (p *jsonParser) list(node *configNode) error {
+	p.advance() // '['
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == ']' {
+		p.advance()
+		return nil
+	}
+
+	for {
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ']' && len(node.items) > 0 {
+			p.advance() // after a trailing comma
+			return nil
+		}
+		item, err := p.value()
+		if err != nil {
+			return err
+		}
+		node.items = append(node.items, item)
+
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.advance()
+			continue
+		}
+		return p.expect(']')
+	}
+}
+
+func (p *jsonParser) str() (string, error) {
+	p.advance() // '"'
+	var text []byte
+	for {
+		if p.pos >= len(p.data) || p.data[p.pos] == '\n' {
+			return "", p.problem("unterminated string")
+		}
+		c := p.data[p.pos]
+		switch {
+		case c == '"':
+			p.advance()
+			return string(text), nil
+		case c < 0x20:
+			return "", p.problem("control character %q in string", c)
+		case c != '\\':
+			text = append(text, c)
+			p.advance()
+			continue
+		}
+
+		if err := p.escape(&text); err != nil {
+			return "", err
+		}
+	}
+}
+
+// Appends what the backslash escape at the current position stands for to
+// text, and moves past it.
+func (p *jsonParser) escape(text *[]byte) error {
+	p.advance() // '\\'
+	if p.pos >= len(p.data) {
+		return p.problem("unterminated string")
+	}
+	switch escape := p.data[p.pos]; escape {
+	case '"', '\\', '/':
+		*text = append(*text, escape)
+	case 'b':
+		*text = append(*text, '\b')
+	case 'f':
+		*text = append(*text, '\f')
+	case 'n':
+		*text = append(*text, '\n')
+	case 'r':
+		*text = append(*text, '\r')
+	case 't':
+		*text = append(*text, '\t')
+	case 'u':
+		r, err := p.unicodeEscape()
+		if err != nil {
+			return err
+		}
+		if utf16.IsSurrogate(r) && p.pos+2 < len(p.data) && p.data[p.pos+1] == '\\' && p.data[p.pos+2] == 'u' {
+			p.advance()
+			p.advance()
+			low, err := p.unicodeEscape()
+			if err != nil {
+				return err
+			}
+			r = utf16.DecodeRune(r, low)
+		}
+		var encoded [utf8.UTFMax]byte
+		*text = append(*text, encoded[:utf8.EncodeRune(encoded[:], r)]...)
+	default:
+		return p.problem("invalid escape '\\%c' in string", escape)
+	}
+	p.advance()
+	return nil
+}
+
+// Reads the four hex digits after a \u, leaving the position on the last.
+func (p *jsonParser) unicodeEscape() (rune, error) {
+	if p.pos+4 >= len(p.data) {
+		return 0, p.problem("unterminated string")
+	}
+	n, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+5]), 16, 16)
+	if err != nil {
+		return 0, p.problem("invalid unicode escape '\\u%s'", p.data[p.pos+1:p.pos+5])
+	}
+	for i := 0; i < 4; i++ {
+		p.advance()
+	}
+	return rune(n), nil
+}
+
+func (p *jsonParser) number() string {
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if !(c >= '0' && c <= '9') && c != '-' && c != '+' && c != '.' && c != 'e' && c != 'E' {
+			break
+		}
+		p.advance()
+	}
+	return string(p.data[start:p.pos])
+}
+
+func (p *jsonParser) literal(word string) bool {
+	end := p.pos + len(word)
+	return end <= len(p.data) && string(p.data[p.pos:end]) == word &&
+		(end == len(p.data) || !isWordByte(p.data[end]))
+}
+
+func (p *jsonParser) word() string {
+	start := p.pos
+	for p.pos < len(p.data) && isWordByte(p.data[p.pos]) {
+		p.advance()
+	}
+	return string(p.data[start:p.pos])
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A problem with the configuration, located in the file it came from where
+// that is known.
+type ConfigProblem struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p *ConfigProblem) Error() string {
+	location := p.File
+	if p.Line > 0 {
+		if location != "" {
+			location += ":"
+		}
+		location += strconv.Itoa(p.Line)
+		if p.Column > 0 {
+			location += ":" + strconv.Itoa(p.Column)
+		}
+	}
+	if location == "" {
+		return p.Message
+	}
+	return location + ": " + p.Message
+}
+
+// An error in one setting, carrying the setting's key (such as "summary
+// interval", or "paths[1]" for an item of a list) relative to what was being
+// checked, so a problem can be located on its line.
+type settingError struct {
+	key     string
+	message string
+}
+
+func (e *settingError) Error() string {
+	return e.message
+}
+
+func settingErrorf(key string, msgfmt string, args ...interface{}) error {
+	return &settingError{key: key, message: fmt.Sprintf(msgfmt, args...)}
+}
+
+// The path of the setting err is about, under prefix. It is prefix itself if
+// err doesn't know its setting.
+func settingPath(prefix string, err error) string {
+	if setting, ok := err.(*settingError); ok && setting.key != "" {
+		return joinPath(prefix, setting.key)
+	}
+	return prefix
+}
+
+// Pairs every event carries, which fields must not redefine.
+var reservedFields = []string{"file", "host", "offset", "line"}
+
+func isReservedField(name string) bool {
+	for _, reserved := range reservedFields {
+		if name == reserved {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckConfigs loads every config file and checks the settings that would
+// otherwise only fail once the forwarder is running: servers, TLS files,
+// globs, durations and field names. Every problem is reported, rather than
+// stopping at the first, on the line of the setting it is about.
+func CheckConfigs(filenames []string) (problems []*ConfigProblem) {
+	var sources []*configSource
+	var entries []configSetting /* of each merged files entry */
+	var merged Config
+
+	for _, filename := range filenames {
+		_, positions, config, err := readConfig(filename)
+		if err != nil {
+			problem, ok := err.(*ConfigProblem)
+			if !ok {
+				problem = &ConfigProblem{Message: err.Error()}
+			}
+			problem.File = filename
+			problems = append(problems, problem)
+			continue
+		}
+		source := &configSource{file: filename, positions: positions}
+		sources = append(sources, source)
+
+		report := func(path string, msgfmt string, args ...interface{}) {
+			problems = append(problems, source.problem(path, msgfmt, args...))
+		}
+
+		checkNetworkConfig(&config.Network, "network", report)
+		names := make([]string, 0, len(config.Outputs))
+		for name := range config.Outputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			output := config.Outputs[name]
+			checkNetworkConfig(&output.Network, joinPath("outputs."+name, "network"), report)
+		}
+		if len(config.Defaults.Paths) > 0 {
+			report("defaults.paths", "paths are not allowed in defaults")
+		}
+		checkFields(config.Fields, "fields", report)
+		switch config.HostnameLookup {
+		case "", "os", "fqdn":
+		default:
+			report("hostname lookup", "unknown hostname lookup '%s', expected 'os' or 'fqdn'", config.HostnameLookup)
+		}
+		if _, err := newRateLimiter(&config.RateLimit); err != nil {
+			report(settingPath("rate limit", err), "invalid rate limit: %s", err)
+		}
+		if config.MaxOpenFiles < 0 {
+			report("max open files", "invalid max open files %d, it must not be negative", config.MaxOpenFiles)
+		}
+		checkFileConfig(&config.Defaults, "defaults", report)
+		for i := range config.Files {
+			fileconfig := &config.Files[i]
+			entry := configSetting{source, fmt.Sprintf("files[%d]", i)}
+			entries = append(entries, entry)
+			if len(fileconfig.Paths) == 0 {
+				report(entry.path, "no paths given for files entry")
+			}
+			checkFileConfig(fileconfig, entry.path, report)
+		}
+
+		MergeConfig(&merged, config)
+	}
+
+	// Outputs and the files routed to them may be in different config files.
+	// Problems with the file entries themselves were reported above; any
+	// other the forwarder would refuse to start with is reported as it would
+	// be at startup, on the line of the entry or output it is about.
+	finalizeOutputs(&merged)
+	for k := range merged.Files {
+		if err := finalizeFileConfig(&merged.Files[k], &merged.Defaults, merged.Fields); err != nil && len(problems) == 0 {
+			problems = append(problems, entries[k].problem(settingPath("", err), "%s", err))
+		}
+	}
+	checked := make(map[string]bool)
+	for k, fileconfig := range merged.Files {
+		for j, name := range fileconfig.Outputs {
+			if checked[name] {
+				continue
+			}
+			checked[name] = true
+
+			output, exists := merged.Outputs[name]
+			if !exists {
+				problems = append(problems, entries[k].problem(fmt.Sprintf("outputs[%d]", j), "unknown output '%s'", name))
+			} else if err := CheckOutputConfig(&output); err != nil {
+				setting := locateOutput(sources, name, settingPath("", err))
+				if setting.source == nil {
+					setting = entries[k]
+				}
+				problems = append(problems, setting.problem("", "invalid output '%s': %s", name, err))
+			}
+		}
+	}
+
+	return problems
+}
+
+// A config file, with the line each of its settings was given on.
+type configSource struct {
+	file      string
+	positions map[string]int
+}
+
+// The problem with the setting at path. If that setting isn't in the file,
+// it is located on the closest setting containing it, such as the files
+// entry a default was inherited by.
+func (s *configSource) problem(path string, msgfmt string, args ...interface{}) *ConfigProblem {
+	problem := &ConfigProblem{File: s.file, Message: fmt.Sprintf(msgfmt, args...)}
+	for path != "" {
+		if line, exists := s.positions[path]; exists {
+			problem.Line = line
+			break
+		}
+		path = strings.TrimRight(path[:strings.LastIndexAny(path, ".[")+1], ".[")
+	}
+	return problem
+}
+
+// A setting in one config file, such as a files entry.
+type configSetting struct {
+	source *configSource
+	path   string
+}
+
+func (s configSetting) problem(key string, msgfmt string, args ...interface{}) *ConfigProblem {
+	path := s.path
+	if key != "" {
+		path = joinPath(path, key)
+	}
+	return s.source.problem(path, msgfmt, args...)
+}
+
+// The setting of the output called name that key (such as "network.servers")
+// is about, in the last config file to give it. The default output may be
+// given by the top-level "output" and "network" settings instead. If no file
+// gives that setting, it is the output itself, and if none gives the output
+// the setting has no source.
+func locateOutput(sources []*configSource, name string, key string) configSetting {
+	roots := []string{"outputs." + name}
+	if name == defaultOutputName {
+		roots = append(roots, "network", "output")
+	}
+	// The default output's network settings are the top-level "network"
+	// ones, and its others the "output" ones
+	settingOf := func(root string) string {
+		network := strings.HasPrefix(key, "network.")
+		switch {
+		case key == "":
+			return root
+		case root == "network" && !network, root == "output" && network:
+			return ""
+		case root == "network":
+			return key
+		}
+		return joinPath(root, key)
+	}
+
+	for i := len(sources) - 1; i >= 0; i-- {
+		for _, root := range roots {
+			setting := settingOf(root)
+			if _, exists := sources[i].positions[setting]; exists && setting != "" {
+				return configSetting{sources[i], setting}
+			}
+		}
+	}
+	for i := len(sources) - 1; i >= 0; i-- {
+		for _, root := range roots {
+			if _, exists := sources[i].positions[root]; exists {
+				return configSetting{sources[i], root}
+			}
+		}
+	}
+	return configSetting{}
+}
+
+// Checks the network settings under prefix, such as "network".
+func checkNetworkConfig(network *NetworkConfig, prefix string, report func(string, string, ...interface{})) {
+	for i, server := range network.Servers {
+		at := joinPath(prefix, fmt.Sprintf("servers[%d]", i))
+		submatch := hostport_re.FindStringSubmatch(server)
+		if submatch == nil {
+			report(at, "invalid server '%s', expected host:port", server)
+			continue
+		}
+		if port, err := strconv.Atoi(submatch[2]); err != nil || port < 1 || port > 65535 {
+			report(at, "invalid port in server '%s'", server)
+		}
+	}
+
+	if (network.SSLCertificate == "") != (network.SSLKey == "") {
+		key := "ssl certificate"
+		if network.SSLCertificate == "" {
+			key = "ssl key"
+		}
+		report(joinPath(prefix, key), "ssl certificate and ssl key must be given together")
+	} else if _, err := loadTLSConfig(network); err != nil {
+		key := "ssl ca"
+		if network.SSLCertificate != "" {
+			key = "ssl certificate"
+		}
+		report(joinPath(prefix, key), "%s", err)
+	}
+}
+
+// Checks the settings of a files entry, or the defaults, under prefix.
+func checkFileConfig(fileconfig *FileConfig, prefix string, report func(string, string, ...interface{})) {
+	at := func(key string) string {
+		return joinPath(prefix, key)
+	}
+
+	if err := checkInput(fileconfig); err != nil {
+		report(settingPath(prefix, err), "%s for %v", err, fileconfig.Paths)
+	}
+	for i, path := range fileconfig.Paths {
+		if _, err := filepath.Match(path, ""); err != nil {
+			report(at(fmt.Sprintf("paths[%d]", i)), "invalid glob '%s': %s", path, err)
+		}
+	}
+
+	if fileconfig.DeadTime != "" {
+		if _, err := time.ParseDuration(fileconfig.DeadTime); err != nil {
+			report(at("dead time"), "invalid dead time: %s", err)
+		}
+	}
+
+	if fileconfig.CloseInactive != "" {
+		if _, err := time.ParseDuration(fileconfig.CloseInactive); err != nil {
+			report(at("close inactive"), "invalid close inactive: %s", err)
+		}
+	}
+	if fileconfig.CloseDeleted != "" {
+		if _, err := time.ParseDuration(fileconfig.CloseDeleted); err != nil {
+			report(at("close deleted"), "invalid close deleted: %s", err)
+		}
+	}
+
+	for i, position := range []string{fileconfig.StartPosition, fileconfig.RotatedStartPosition} {
+		if position == "" {
+			continue
+		}
+		if _, err := parseStartPosition(position); err != nil {
+			report(at([]string{"start position", "rotated start position"}[i]), "%s for %v", err, fileconfig.Paths)
+		}
+	}
+
+	if _, err := newSyslogHeader(fileconfig); err != nil {
+		report(settingPath(prefix, err), "invalid syslog settings for %v: %s", fileconfig.Paths, err)
+	}
+
+	if _, err := newTimestampParser(fileconfig); err != nil {
+		report(settingPath(prefix, err), "invalid timestamp settings for %v: %s", fileconfig.Paths, err)
+	}
+
+	for k, patterns := range [][]string{fileconfig.IncludeLines, fileconfig.ExcludeLines} {
+		for i, pattern := range patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				report(at(fmt.Sprintf("%s[%d]", []string{"include lines", "exclude lines"}[k], i)), "invalid line pattern '%s' for %v: %s", pattern, fileconfig.Paths, err)
+			}
+		}
+	}
+
+	for i := range fileconfig.Processors {
+		if _, err := newProcessor(&fileconfig.Processors[i]); err != nil {
+			report(at(fmt.Sprintf("processors[%d]", i)), "invalid processor for %v: %s", fileconfig.Paths, err)
+		}
+	}
+
+	if _, err := newRateLimiter(&fileconfig.RateLimit); err != nil {
+		report(settingPath(at("rate limit"), err), "invalid rate limit for %v: %s", fileconfig.Paths, err)
+	}
+
+	if fileconfig.FingerprintBytes < 0 {
+		report(at("fingerprint bytes"), "invalid fingerprint bytes %d for %v, it must not be negative", fileconfig.FingerprintBytes, fileconfig.Paths)
+	}
+	if fileconfig.Priority < 0 {
+		report(at("priority"), "invalid priority %d for %v, it must be at least 1", fileconfig.Priority, fileconfig.Paths)
+	}
+
+	checkFields(fileconfig.Fields, at("fields"), report)
+	for i, name := range fileconfig.Metadata {
+		if err := checkMetadata([]string{name}); err != nil {
+			report(at(fmt.Sprintf("metadata[%d]", i)), "%s for %v", err, fileconfig.Paths)
+		} else if _, exists := fileconfig.Fields[name]; exists {
+			report(at(fmt.Sprintf("metadata[%d]", i)), "field '%s' is also sent as metadata", name)
+		}
+	}
+	for i, tag := range fileconfig.Tags {
+		if tag == "" || strings.Contains(tag, ",") {
+			report(at(fmt.Sprintf("tags[%d]", i)), "invalid tag '%s' for %v, tags must be non-empty and without commas", tag, fileconfig.Paths)
+		}
+	}
+}
+
+// Checks the field names under prefix, such as "fields".
+func checkFields(fields map[string]string, prefix string, report func(string, string, ...interface{})) {
+	for name := range fields {
+		if name == "" {
+			report(prefix, "empty field name")
+		}
+		if isReservedField(name) {
+			report(joinPath(prefix, name), "field '%s' is reserved", name)
+		}
+	}
+}
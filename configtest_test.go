@@ -0,0 +1,167 @@
+package main
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+func TestCheckConfigs(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	network := path.Join(tmpdir, "network.json")
+	files := path.Join(tmpdir, "files.json")
+	chkerr(t, ioutil.WriteFile(network, []byte(`{
+  # Comments are skipped when finding lines: "localhost"
+  "network": {
+    "servers": [ "localhost", "ok:5043" ]
+  }
+}`), 0644))
+	chkerr(t, ioutil.WriteFile(files, []byte(`{
+  "files": [{
+    "paths": [ "/var/log/[abc.log" ],
+    "dead time": "6 hours",
+    "fields": { "host": "x" },
+    "outputs": [ "default", "siem" ]
+  }]
+}`), 0644))
+
+	problems := CheckConfigs([]string{network, files})
+
+	expected := []string{
+		network + ":4: invalid server 'localhost', expected host:port",
+		files + ":3: invalid glob '/var/log/[abc.log': syntax error in pattern",
+		files + ":4: invalid dead time: time: unknown unit \" hours\" in duration \"6 hours\"",
+		files + ":5: field 'host' is reserved",
+		files + ":6: unknown output 'siem'",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if problem.Error() != expected[i] {
+			t.Errorf("Expected problem\n%s\ngot\n%s", expected[i], problem)
+		}
+	}
+}
+
+func TestCheckConfigsDecodeError(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	configFile := path.Join(tmpdir, "myconfig")
+	chkerr(t, ioutil.WriteFile(configFile, []byte("{\n  # comment\n  \"files\": [\n    { \"paths\": 5 }\n  ]\n}"), 0644))
+
+	problems := CheckConfigs([]string{configFile})
+	if len(problems) != 1 || problems[0].File != configFile || problems[0].Line != 4 {
+		t.Fatalf("Expected a decode error on line 4, got %v", problems)
+	}
+}
+
+func TestCheckConfigsMerged(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	// Each part is fine on its own, but the entry inherits an input its path
+	// can't be read with
+	base := path.Join(tmpdir, "00-base.json")
+	dropin := path.Join(tmpdir, "50-app.json")
+	chkerr(t, ioutil.WriteFile(base, []byte(`{ "defaults": { "input": "stdin" } }`), 0644))
+	chkerr(t, ioutil.WriteFile(dropin, []byte(`{ "files": [{ "paths": [ "/var/log/app.log" ] }] }`), 0644))
+
+	problems := CheckConfigs([]string{base, dropin})
+	if len(problems) != 1 || problems[0].Error() != dropin+":1: Invalid input for [/var/log/app.log]: the path of standard input is '-', not '/var/log/app.log'" {
+		t.Fatalf("Expected the merged config to be refused, got %v", problems)
+	}
+}
+
+func TestCheckConfigsSettingLines(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	configFile := path.Join(tmpdir, "files.json")
+	chkerr(t, ioutil.WriteFile(configFile, []byte(`{
+  "files": [{
+    "paths": [ "/var/log/a.log" ],
+    "timestamp format": "%Y-%m-%d",
+    "timezone": "Mars/Olympus"
+  }, {
+    "paths": [ "/var/log/b.log" ],
+    "timestamp layout": "2006-01-02"
+  }, {
+    "paths": [ "/var/log/c.log" ],
+    "rate limit": { "lines": 10, "summary interval": "5 minutes" }
+  }, {
+    "paths": [ "/run/app.sock" ],
+    "input": "tcp"
+  }]
+}`), 0644))
+
+	problems := CheckConfigs([]string{configFile})
+	expected := []int{5, 8, 11, 14}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if problem.Line != expected[i] {
+			t.Errorf("Expected problem %q on line %d, got %d", problem.Message, expected[i], problem.Line)
+		}
+	}
+}
+
+func TestCheckConfigsSettingPositions(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	// Values repeated across entries, and numbers, are located by the
+	// setting they were given for
+	configFile := path.Join(tmpdir, "files.json")
+	outputs := path.Join(tmpdir, "outputs.json")
+	chkerr(t, ioutil.WriteFile(configFile, []byte(`{
+  "max open files": -1,
+  "files": [{
+    "paths": [ "/var/log/a.log" ],
+    "rate limit": { "lines": 10 }
+  }, {
+    "paths": [ "/var/log/b.log" ],
+    "rate limit": {
+      "lines": -10
+    },
+    "syslog facility": "nowhere",
+    "priority": -1,
+    "fingerprint bytes": -1,
+    "outputs": [ "siem" ]
+  }]
+}`), 0644))
+	chkerr(t, ioutil.WriteFile(outputs, []byte(`{
+  "outputs": {
+    "siem": {
+      "type": "network",
+      "network": { "timeout": 5 }
+    }
+  }
+}`), 0644))
+
+	problems := CheckConfigs([]string{configFile, outputs})
+	expected := []struct {
+		file string
+		line int
+	}{
+		{configFile, 2},
+		{configFile, 11},
+		{configFile, 9},
+		{configFile, 13},
+		{configFile, 12},
+		{configFile, 3}, /* the entry routed to the default output */
+		{outputs, 3},
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %d problems, got %v", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if problem.File != expected[i].file || problem.Line != expected[i].line {
+			t.Errorf("Expected problem %q at %s:%d, got %s:%d", problem.Message, expected[i].file, expected[i].line, problem.File, problem.Line)
+		}
+	}
+}
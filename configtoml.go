@@ -0,0 +1,421 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Parses the subset of TOML that configs need into a configNode tree:
+// [tables], [[arrays of tables]], bare, quoted and dotted keys, strings,
+// integers, floats, booleans, arrays and inline tables. Dates and times, and
+// \U escapes, are not supported.
+func parseTOMLConfig(data []byte) (*configNode, error) {
+	p := &tomlParser{
+		jsonParser: jsonParser{data: data, line: 1, column: 1},
+		root:       &configNode{kind: mapNode, line: 1, column: 1},
+		headers:    make(map[*configNode]bool),
+		inline:     make(map[*configNode]bool),
+	}
+	p.table = p.root
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return p.root, nil
+		}
+
+		var err error
+		if p.data[p.pos] == '[' {
+			err = p.header()
+		} else {
+			err = p.keyValue(p.table)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err = p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type tomlParser struct {
+	jsonParser
+	root    *configNode
+	table   *configNode          /* that key/value pairs go into */
+	headers map[*configNode]bool /* tables defined by a [header] */
+	inline  map[*configNode]bool /* inline tables and arrays, which are complete */
+}
+
+// Skips blanks and any comment up to the end of the line.
+func (p *tomlParser) skipBlanks() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.advance()
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '#' {
+		for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+			p.advance()
+		}
+	}
+}
+
+func (p *tomlParser) endOfLine() error {
+	p.skipBlanks()
+	if p.pos < len(p.data) && p.data[p.pos] == '\r' {
+		p.advance()
+	}
+	if p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		return p.problem("expected the end of the line, got %s", p.describe())
+	}
+	return nil
+}
+
+func (p *tomlParser) header() error {
+	p.advance() // '['
+	array := p.pos < len(p.data) && p.data[p.pos] == '['
+	if array {
+		p.advance()
+	}
+
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	table := p.root
+	for _, key := range keys[:len(keys)-1] {
+		if table, err = p.child(table, key); err != nil {
+			return err
+		}
+	}
+
+	last := keys[len(keys)-1]
+	existing := lookupEntry(table, last.key)
+	if array {
+		if existing == nil {
+			existing = &configEntry{key: last.key, line: last.line, column: last.column,
+				value: &configNode{kind: listNode, line: last.line, column: last.column}}
+			table.entries = append(table.entries, *existing)
+			existing = &table.entries[len(table.entries)-1]
+		} else if existing.value.kind != listNode {
+			return &ConfigProblem{Line: last.line, Column: last.column, Message: "key \"" + last.key + "\" is not an array of tables"}
+		} else if p.inline[existing.value] {
+			return &ConfigProblem{Line: last.line, Column: last.column, Message: "array \"" + last.key + "\" was given inline and can't be added to"}
+		}
+		p.table = &configNode{kind: mapNode, line: last.line, column: last.column}
+		existing.value.items = append(existing.value.items, p.table)
+	} else {
+		if p.table, err = p.child(table, last); err != nil {
+			return err
+		}
+		if p.headers[p.table] {
+			return &ConfigProblem{Line: last.line, Column: last.column, Message: "table [" + joinKeys(keys) + "] defined twice"}
+		}
+	}
+	p.headers[p.table] = true
+
+	if err := p.expect(']'); err != nil {
+		return err
+	}
+	if array {
+		if p.pos >= len(p.data) || p.data[p.pos] != ']' {
+			return p.problem("expected ']]', got %s", p.describe())
+		}
+		p.advance()
+	}
+	return nil
+}
+
+// The table key names in parent, created if it doesn't exist yet. The last
+// table of an array of tables is used.
+func (p *tomlParser) child(parent *configNode, key configEntry) (*configNode, error) {
+	entry := lookupEntry(parent, key.key)
+	if entry == nil {
+		key.value = &configNode{kind: mapNode, line: key.line, column: key.column}
+		parent.entries = append(parent.entries, key)
+		return key.value, nil
+	}
+	if p.inline[entry.value] {
+		return nil, &ConfigProblem{Line: key.line, Column: key.column, Message: "key \"" + key.key + "\" was given inline and can't be added to"}
+	}
+	switch entry.value.kind {
+	case mapNode:
+		return entry.value, nil
+	case listNode:
+		if n := len(entry.value.items); n > 0 && entry.value.items[n-1].kind == mapNode {
+			return entry.value.items[n-1], nil
+		}
+	}
+	return nil, &ConfigProblem{Line: key.line, Column: key.column, Message: "key \"" + key.key + "\" is not a table"}
+}
+
+func lookupEntry(node *configNode, key string) *configEntry {
+	for i := range node.entries {
+		if node.entries[i].key == key {
+			return &node.entries[i]
+		}
+	}
+	return nil
+}
+
+func joinKeys(keys []configEntry) string {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.key
+	}
+	return strings.Join(names, ".")
+}
+
+func (p *tomlParser) keyValue(table *configNode) error {
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys[:len(keys)-1] {
+		if table, err = p.child(table, key); err != nil {
+			return err
+		}
+	}
+
+	entry := keys[len(keys)-1]
+	if lookupEntry(table, entry.key) != nil {
+		return &ConfigProblem{Line: entry.line, Column: entry.column, Message: "key \"" + joinKeys(keys) + "\" defined twice"}
+	}
+	p.skipBlanks()
+	if p.pos < len(p.data) && isWordByte(p.data[p.pos]) {
+		return p.problem("expected '=' after key \"%s\"; keys with spaces must be quoted", joinKeys(keys))
+	}
+	if p.pos >= len(p.data) || p.data[p.pos] != '=' {
+		return p.problem("expected '=' after key \"%s\", got %s", joinKeys(keys), p.describe())
+	}
+	p.advance()
+	p.skipBlanks()
+
+	if entry.value, err = p.value(); err != nil {
+		return err
+	}
+	table.entries = append(table.entries, entry)
+	return nil
+}
+
+// Reads a possibly dotted key.
+func (p *tomlParser) key() (keys []configEntry, err error) {
+	for {
+		p.skipBlanks()
+		entry := configEntry{line: p.line, column: p.column}
+		switch {
+		case p.pos >= len(p.data):
+			return nil, p.problem("expected a key, got end of file")
+		case p.data[p.pos] == '"':
+			entry.key, err = p.str()
+		case p.data[p.pos] == '\'':
+			entry.key, err = p.literalString()
+		default:
+			start := p.pos
+			for p.pos < len(p.data) && (isWordByte(p.data[p.pos]) || p.data[p.pos] == '-') {
+				p.advance()
+			}
+			if p.pos == start {
+				return nil, p.problem("expected a key, got %s", p.describe())
+			}
+			entry.key = string(p.data[start:p.pos])
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, entry)
+
+		p.skipBlanks()
+		if p.pos >= len(p.data) || p.data[p.pos] != '.' {
+			return keys, nil
+		}
+		p.advance()
+	}
+}
+
+func (p *tomlParser) value() (*configNode, error) {
+	if p.pos >= len(p.data) {
+		return nil, p.problem("unexpected end of file, expected a value")
+	}
+	node := &configNode{kind: stringNode, line: p.line, column: p.column}
+	var err error
+
+	switch c := p.data[p.pos]; {
+	case p.startsWith(`"""`):
+		node.text, err = p.multilineString(`"""`)
+	case p.startsWith(`'''`):
+		node.text, err = p.multilineString(`'''`)
+	case c == '"':
+		node.text, err = p.str()
+	case c == '\'':
+		node.text, err = p.literalString()
+	case c == '[':
+		node.kind = listNode
+		err = p.array(node)
+		p.inline[node] = true
+	case c == '{':
+		node.kind = mapNode
+		err = p.inlineTable(node)
+		p.inline[node] = true
+	case p.literal("true") || p.literal("false"):
+		node.kind = boolNode
+		node.text = p.word()
+	case c == '+' || c == '-' || (c >= '0' && c <= '9'):
+		node.kind = numberNode
+		node.text, err = p.tomlNumber()
+	default:
+		return nil, p.problem("unexpected %s, expected a value", p.describe())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (p *tomlParser) startsWith(prefix string) bool {
+	return strings.HasPrefix(string(p.data[p.pos:]), prefix)
+}
+
+func (p *tomlParser) literalString() (string, error) {
+	p.advance() // '\''
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] != '\'' {
+		if p.data[p.pos] == '\n' {
+			return "", p.problem("unterminated string")
+		}
+		p.advance()
+	}
+	if p.pos >= len(p.data) {
+		return "", p.problem("unterminated string")
+	}
+	text := string(p.data[start:p.pos])
+	p.advance()
+	return text, nil
+}
+
+// Reads a multi-line string, basic or literal as quotes says. A newline
+// straight after the opening quotes is dropped, and in basic strings escapes
+// are processed and a backslash at the end of a line joins it to the next
+// non-blank text.
+func (p *tomlParser) multilineString(quotes string) (string, error) {
+	for i := 0; i < 3; i++ {
+		p.advance()
+	}
+	if p.startsWith("\r\n") {
+		p.advance()
+	}
+	if p.startsWith("\n") {
+		p.advance()
+	}
+
+	var text []byte
+	for {
+		if p.pos >= len(p.data) {
+			return "", p.problem("unterminated string")
+		}
+		if p.startsWith(quotes) {
+			for i := 0; i < 3; i++ {
+				p.advance()
+			}
+			return string(text), nil
+		}
+
+		c := p.data[p.pos]
+		if c != '\\' || quotes == `'''` {
+			text = append(text, c)
+			p.advance()
+			continue
+		}
+
+		// Line-ending backslash
+		rest := strings.TrimLeft(string(p.data[p.pos+1:]), " \t\r")
+		if strings.HasPrefix(rest, "\n") {
+			p.advance()
+			for p.pos < len(p.data) && strings.IndexByte(" \t\r\n", p.data[p.pos]) >= 0 {
+				p.advance()
+			}
+			continue
+		}
+
+		if err := p.escape(&text); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (p *tomlParser) array(node *configNode) error {
+	p.advance() // '['
+	for {
+		p.skipSpace() // arrays may span lines, with comments
+		if p.pos < len(p.data) && p.data[p.pos] == ']' {
+			p.advance()
+			return nil
+		}
+		item, err := p.value()
+		if err != nil {
+			return err
+		}
+		node.items = append(node.items, item)
+
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.advance()
+			continue
+		}
+		return p.expect(']')
+	}
+}
+
+func (p *tomlParser) inlineTable(node *configNode) error {
+	p.advance() // '{'
+	p.skipBlanks()
+	if p.pos < len(p.data) && p.data[p.pos] == '}' {
+		p.advance()
+		return nil
+	}
+	for {
+		if err := p.keyValue(node); err != nil {
+			return err
+		}
+		p.skipBlanks()
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.advance()
+			p.skipBlanks()
+			if p.pos < len(p.data) && p.data[p.pos] == '}' {
+				return p.problem("trailing commas are not allowed in inline tables")
+			}
+			continue
+		}
+		if p.pos >= len(p.data) || p.data[p.pos] == '\n' || p.data[p.pos] == '\r' {
+			return p.problem("inline tables must be on one line")
+		}
+		if p.data[p.pos] != '}' {
+			return p.problem("expected ',' or '}', got %s", p.describe())
+		}
+		p.advance()
+		return nil
+	}
+}
+
+// Reads an integer or float, returning it in a form strconv can parse.
+func (p *tomlParser) tomlNumber() (string, error) {
+	line, column := p.line, p.column
+	start := p.pos
+	for p.pos < len(p.data) && (isWordByte(p.data[p.pos]) || strings.IndexByte("+-.:", p.data[p.pos]) >= 0) {
+		p.advance()
+	}
+	text := string(p.data[start:p.pos])
+
+	if strings.ContainsAny(text, ":") || strings.Count(text, "-") > 1 && !strings.ContainsAny(text, "eE") {
+		return "", &ConfigProblem{Line: line, Column: column, Message: "dates and times are not supported; quote the value if it is a string"}
+	}
+	text = strings.Replace(text, "_", "", -1)
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0o") || strings.HasPrefix(text, "0b") {
+		base := map[byte]int{'x': 16, 'o': 8, 'b': 2}[text[1]]
+		n, err := strconv.ParseInt(text[2:], base, 64)
+		if err != nil {
+			return "", &ConfigProblem{Line: line, Column: column, Message: "invalid number " + text}
+		}
+		text = strconv.FormatInt(n, 10)
+	}
+	return text, nil
+}
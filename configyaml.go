@@ -0,0 +1,589 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Parses the subset of YAML that configs need into a configNode tree: block
+// mappings and sequences, flow [lists] and {maps}, plain and quoted scalars,
+// | and > block scalars, and comments. Anchors, aliases, tags, complex keys,
+// directives and multiple documents are not supported.
+func parseYAMLConfig(data []byte) (*configNode, error) {
+	p := &yamlParser{}
+	for i, text := range strings.Split(string(data), "\n") {
+		text = strings.TrimRight(text, "\r")
+		content := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(content, "\t") && stripYAMLComment(content) != "" {
+			return nil, &ConfigProblem{Line: i + 1, Column: len(text) - len(content) + 1, Message: "tabs are not allowed for indentation"}
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(text) - len(content), text: content})
+	}
+
+	p.skipBlank()
+	if p.pos < len(p.lines) && strings.HasPrefix(p.lines[p.pos].text, "%") {
+		return nil, p.problem(p.pos, 0, "YAML directives are not supported")
+	}
+	if p.pos < len(p.lines) && stripYAMLComment(p.lines[p.pos].text) == "---" {
+		p.pos++
+		p.skipBlank()
+	}
+	if err := p.checkOneDocument(); err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.lines) {
+		return &configNode{kind: mapNode, line: 1, column: 1}, nil
+	}
+
+	node, err := p.block(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.pos < len(p.lines) {
+		return nil, p.problem(p.pos, 0, "unexpected content after the end of the config")
+	}
+	return node, nil
+}
+
+// Checks there is only the one document, which may be ended by "..." if
+// nothing follows it. Its lines are dropped.
+func (p *yamlParser) checkOneDocument() error {
+	for i := p.pos; i < len(p.lines); i++ {
+		if p.lines[i].indent > 0 {
+			continue
+		}
+		switch content := stripYAMLComment(p.lines[i].text); {
+		case content == "---" || strings.HasPrefix(content, "--- "):
+			return p.problem(i, 0, "multiple YAML documents are not supported; use a config file for each")
+		case content == "...":
+			for j := i + 1; j < len(p.lines); j++ {
+				if stripYAMLComment(p.lines[j].text) != "" {
+					return p.problem(j, 0, "multiple YAML documents are not supported; use a config file for each")
+				}
+			}
+			p.lines = p.lines[:i]
+			return nil
+		}
+	}
+	return nil
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+type yamlLine struct {
+	number int
+	indent int
+	text   string /* after the indent, including any comment */
+}
+
+func (p *yamlParser) problem(index, offset int, msgfmt string, args ...interface{}) *ConfigProblem {
+	line := p.lines[index]
+	return &ConfigProblem{Line: line.number, Column: line.indent + offset + 1, Message: fmt.Sprintf(msgfmt, args...)}
+}
+
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && stripYAMLComment(p.lines[p.pos].text) == "" {
+		p.pos++
+	}
+}
+
+// Parses the block starting on the current line, which is at indent.
+func (p *yamlParser) block(indent int) (*configNode, error) {
+	content := stripYAMLComment(p.lines[p.pos].text)
+	if isYAMLSequenceItem(content) {
+		return p.sequence(indent)
+	}
+	if content == "?" || strings.HasPrefix(content, "? ") {
+		return nil, p.problem(p.pos, 0, "YAML complex keys are not supported")
+	}
+	if _, _, ok := splitYAMLKey(content); ok {
+		return p.mapping(indent)
+	}
+	return p.value(indent-1, 0)
+}
+
+func isYAMLSequenceItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+func (p *yamlParser) sequence(indent int) (*configNode, error) {
+	line := &p.lines[p.pos]
+	node := &configNode{kind: listNode, line: line.number, column: indent + 1}
+
+	for p.pos < len(p.lines) {
+		line = &p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.problem(p.pos, 0, "unexpected indentation")
+		}
+		content := stripYAMLComment(line.text)
+		if !isYAMLSequenceItem(content) {
+			break
+		}
+
+		var item *configNode
+		var err error
+		rest := strings.TrimLeft(line.text[1:], " ")
+		if stripYAMLComment(rest) == "" {
+			item = &configNode{kind: nullNode, line: line.number, column: indent + 1}
+			p.pos++
+			p.skipBlank()
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				item, err = p.block(p.lines[p.pos].indent)
+			}
+		} else {
+			// Parse the rest of the line as a block starting at its column,
+			// so "- key: value" begins a mapping indented past the dash
+			line.indent += len(line.text) - len(rest)
+			line.text = rest
+			item, err = p.block(line.indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.items = append(node.items, item)
+		p.skipBlank()
+	}
+	return node, nil
+}
+
+func (p *yamlParser) mapping(indent int) (*configNode, error) {
+	node := &configNode{kind: mapNode, line: p.lines[p.pos].number, column: indent + 1}
+
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.problem(p.pos, 0, "unexpected indentation")
+		}
+		content := stripYAMLComment(line.text)
+		key, offset, ok := splitYAMLKey(content)
+		if !ok {
+			if content == "?" || strings.HasPrefix(content, "? ") {
+				return nil, p.problem(p.pos, 0, "YAML complex keys are not supported")
+			}
+			if isYAMLSequenceItem(content) {
+				return nil, p.problem(p.pos, 0, "unexpected list item, expected a key")
+			}
+			return nil, p.problem(p.pos, 0, "expected 'key: value'")
+		}
+		entry := configEntry{key: key, line: line.number, column: indent + 1}
+
+		var err error
+		if offset == len(content) {
+			entry.value = &configNode{kind: nullNode, line: line.number, column: indent + 1}
+			p.pos++
+			p.skipBlank()
+			if p.pos < len(p.lines) {
+				next := p.lines[p.pos]
+				if next.indent > indent {
+					entry.value, err = p.block(next.indent)
+				} else if next.indent == indent && isYAMLSequenceItem(stripYAMLComment(next.text)) {
+					// A list may sit at the same indent as its key
+					entry.value, err = p.sequence(indent)
+				}
+			}
+		} else {
+			entry.value, err = p.value(indent, offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.entries = append(node.entries, entry)
+		p.skipBlank()
+	}
+	return node, nil
+}
+
+// Splits "key: value" into the key and the offset of the value, which is the
+// length of the content if there is none.
+func splitYAMLKey(content string) (key string, offset int, ok bool) {
+	if content == "" || isYAMLSequenceItem(content) || strings.ContainsAny(content[:1], "[{&*!|>") {
+		return "", 0, false
+	}
+
+	end := 0
+	switch content[0] {
+	case '"':
+		p := &jsonParser{data: []byte(content)}
+		text, err := p.str()
+		if err != nil {
+			return "", 0, false
+		}
+		key, end = text, p.pos
+	case '\'':
+		text, n, ok := yamlSingleQuoted(content)
+		if !ok {
+			return "", 0, false
+		}
+		key, end = text, n
+	default:
+		end = strings.Index(content, ": ")
+		if end < 0 {
+			if !strings.HasSuffix(content, ":") {
+				return "", 0, false
+			}
+			end = len(content) - 1
+		}
+		key = strings.TrimRight(content[:end], " ")
+	}
+
+	rest := content[end:]
+	trimmed := strings.TrimLeft(rest, " ")
+	if !strings.HasPrefix(trimmed, ":") || (len(trimmed) > 1 && trimmed[1] != ' ') {
+		return "", 0, false
+	}
+	value := strings.TrimLeft(trimmed[1:], " ")
+	return key, len(content) - len(value), true
+}
+
+// Parses the value starting at offset in the current line's text, and any
+// lines it continues onto. parent is the indent of the block it belongs to.
+func (p *yamlParser) value(parent, offset int) (*configNode, error) {
+	line := p.lines[p.pos]
+	text := stripYAMLComment(line.text)[offset:]
+	node := &configNode{kind: stringNode, line: line.number, column: line.indent + offset + 1}
+
+	switch text[0] {
+	case '|', '>':
+		return node, p.blockScalar(node, text, parent)
+	case '[', '{':
+		f := &yamlFlow{p: p, offset: offset, start: node}
+		flow, err := f.value()
+		if err != nil {
+			return nil, err
+		}
+		if rest := stripYAMLComment(p.lines[p.pos].text[f.offset:]); strings.TrimSpace(rest) != "" {
+			return nil, p.problem(p.pos, f.offset, "unexpected content after %s", nodeKindNames[flow.kind])
+		}
+		p.pos++
+		return flow, nil
+	case '"':
+		jp := &jsonParser{data: []byte(text), line: node.line, column: node.column}
+		str, err := jp.str()
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimSpace(text[jp.pos:]) != "" {
+			return nil, p.problem(p.pos, offset+jp.pos, "unexpected content after a quoted string")
+		}
+		node.text = str
+	case '\'':
+		str, n, ok := yamlSingleQuoted(text)
+		if !ok {
+			return nil, p.problem(p.pos, offset, "unterminated string")
+		}
+		if strings.TrimSpace(text[n:]) != "" {
+			return nil, p.problem(p.pos, offset+n, "unexpected content after a quoted string")
+		}
+		node.text = str
+	case '&', '*', '!':
+		return nil, p.problem(p.pos, offset, "YAML anchors, aliases and tags are not supported; quote the value if it is a string")
+	default:
+		if i := strings.Index(text, ": "); i >= 0 {
+			return nil, p.problem(p.pos, offset+i, "unexpected ': ' in a value; quote the value if it is a string")
+		}
+		node = yamlPlainScalar(text, node.line, node.column)
+	}
+	p.pos++
+	return node, nil
+}
+
+// Reads a literal (|) or folded (>) block scalar, whose lines are indented
+// past parent.
+func (p *yamlParser) blockScalar(node *configNode, header string, parent int) error {
+	chomp := byte(0)
+	for i := 1; i < len(header); i++ {
+		switch c := header[i]; c {
+		case '-', '+':
+			chomp = c
+		default:
+			return &ConfigProblem{Line: node.line, Column: node.column + i, Message: fmt.Sprintf("unsupported block scalar indicator '%c'", c)}
+		}
+	}
+	p.pos++
+
+	var lines []string
+	indent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if strings.TrimSpace(line.text) == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if indent < 0 {
+			if line.indent <= parent {
+				break
+			}
+			indent = line.indent
+		}
+		if line.indent < indent {
+			break
+		}
+		lines = append(lines, strings.Repeat(" ", line.indent-indent)+line.text)
+	}
+
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	// Trailing blank lines belong to the scalar, but the ones we read past
+	// may separate it from what follows.
+	p.pos -= trailing
+
+	var text string
+	if header[0] == '|' {
+		text = strings.Join(lines, "\n")
+	} else {
+		for i, line := range lines {
+			switch {
+			case i == 0:
+				text = line
+			case line == "":
+				text += "\n"
+			case lines[i-1] == "":
+				text += line
+			case strings.HasPrefix(line, " ") || strings.HasPrefix(lines[i-1], " "):
+				text += "\n" + line
+			default:
+				text += " " + line
+			}
+		}
+	}
+
+	if len(lines) > 0 {
+		switch chomp {
+		case 0:
+			text += "\n"
+		case '+':
+			text += "\n" + strings.Repeat("\n", trailing)
+		}
+	}
+	node.text = text
+	return nil
+}
+
+var yamlNumber_re = regexp.MustCompile(`^[-+]?([0-9]+|[0-9]*\.[0-9]+|[0-9]+\.[0-9]*)([eE][-+]?[0-9]+)?$`)
+
+// Resolves an unquoted scalar to null, a boolean, a number or a string. It
+// is marked plain, so it may still be read as a string: 1.0 is a fine
+// version field.
+func yamlPlainScalar(text string, line, column int) *configNode {
+	node := &configNode{kind: stringNode, line: line, column: column, text: text, plain: true}
+	switch text {
+	case "~", "null", "Null", "NULL":
+		node.kind = nullNode
+	case "true", "True", "TRUE":
+		node.kind, node.text = boolNode, "true"
+	case "false", "False", "FALSE":
+		node.kind, node.text = boolNode, "false"
+	default:
+		if yamlNumber_re.MatchString(text) {
+			node.kind = numberNode
+		}
+	}
+	return node
+}
+
+// Reads a single-quoted string at the start of text, in which a doubled
+// quote stands for one. Returns the string and the length read.
+func yamlSingleQuoted(text string) (string, int, bool) {
+	var str []byte
+	for i := 1; i < len(text); i++ {
+		if text[i] != '\'' {
+			str = append(str, text[i])
+		} else if i+1 < len(text) && text[i+1] == '\'' {
+			str = append(str, '\'')
+			i++
+		} else {
+			return string(str), i + 1, true
+		}
+	}
+	return "", 0, false
+}
+
+// Removes a comment, which starts with a '#' at the start of text or after a
+// blank outside of quotes, and any trailing blanks.
+func stripYAMLComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t[{,:-", rune(text[i-1])) {
+				quote = c
+			}
+		case c == '#':
+			if i == 0 || text[i-1] == ' ' || text[i-1] == '\t' {
+				return strings.TrimRight(text[:i], " \t")
+			}
+		}
+	}
+	return strings.TrimRight(text, " \t")
+}
+
+// A flow collection, which may continue over several lines.
+type yamlFlow struct {
+	p      *yamlParser
+	offset int /* into the text of the current line */
+	start  *configNode
+}
+
+func (f *yamlFlow) problem(msgfmt string, args ...interface{}) *ConfigProblem {
+	return f.p.problem(f.p.pos, f.offset, msgfmt, args...)
+}
+
+// The next character, moving on to the next line at the end of one.
+func (f *yamlFlow) peek() (byte, error) {
+	for {
+		text := f.p.lines[f.p.pos].text
+		for f.offset < len(text) && (text[f.offset] == ' ' || text[f.offset] == '\t') {
+			f.offset++
+		}
+		if f.offset < len(text) && text[f.offset] != '#' {
+			return text[f.offset], nil
+		}
+		if f.p.pos+1 >= len(f.p.lines) {
+			return 0, f.start.problem("unterminated flow collection")
+		}
+		f.p.pos++
+		f.offset = 0
+	}
+}
+
+func (f *yamlFlow) node(kind nodeKind) *configNode {
+	line := f.p.lines[f.p.pos]
+	return &configNode{kind: kind, line: line.number, column: line.indent + f.offset + 1}
+}
+
+func (f *yamlFlow) value() (*configNode, error) {
+	c, err := f.peek()
+	if err != nil {
+		return nil, err
+	}
+	switch c {
+	case '[':
+		return f.collection(listNode, ']')
+	case '{':
+		return f.collection(mapNode, '}')
+	}
+	return f.scalar()
+}
+
+func (f *yamlFlow) collection(kind nodeKind, end byte) (*configNode, error) {
+	node := f.node(kind)
+	f.offset++
+	for {
+		c, err := f.peek()
+		if err != nil {
+			return nil, err
+		}
+		if c == end {
+			f.offset++
+			return node, nil
+		}
+
+		if kind == listNode {
+			item, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			node.items = append(node.items, item)
+		} else {
+			key, err := f.scalar()
+			if err != nil {
+				return nil, err
+			}
+			if c, err = f.peek(); err != nil {
+				return nil, err
+			}
+			if c != ':' {
+				return nil, f.problem("expected ':' after key %q", key.text)
+			}
+			f.offset++
+			entry := configEntry{key: key.text, line: key.line, column: key.column}
+			if entry.value, err = f.value(); err != nil {
+				return nil, err
+			}
+			node.entries = append(node.entries, entry)
+		}
+
+		if c, err = f.peek(); err != nil {
+			return nil, err
+		}
+		switch c {
+		case ',':
+			f.offset++
+		case end:
+		default:
+			return nil, f.problem("expected ',' or '%c', got '%c'", end, c)
+		}
+	}
+}
+
+func (f *yamlFlow) scalar() (*configNode, error) {
+	node := f.node(stringNode)
+	text := f.p.lines[f.p.pos].text[f.offset:]
+
+	switch text[0] {
+	case '"':
+		jp := &jsonParser{data: []byte(text), line: node.line, column: node.column}
+		str, err := jp.str()
+		if err != nil {
+			return nil, err
+		}
+		node.text = str
+		f.offset += jp.pos
+		return node, nil
+	case '\'':
+		str, n, ok := yamlSingleQuoted(text)
+		if !ok {
+			return nil, f.problem("unterminated string")
+		}
+		node.text = str
+		f.offset += n
+		return node, nil
+	case '&', '*', '!':
+		return nil, f.problem("YAML anchors, aliases and tags are not supported; quote the value if it is a string")
+	}
+
+	end := 0
+	for end < len(text) && !endsYAMLFlowScalar(text, end) {
+		end++
+	}
+	if end == 0 {
+		return nil, f.problem("expected a value, got '%c'", text[0])
+	}
+	f.offset += end
+	return yamlPlainScalar(strings.TrimRight(text[:end], " \t"), node.line, node.column), nil
+}
+
+// Whether a plain scalar in a flow collection ends at text[i]: at an
+// indicator, a comment, or a ':' that separates a key from its value.
+func endsYAMLFlowScalar(text string, i int) bool {
+	switch text[i] {
+	case ',', '[', ']', '{', '}':
+		return true
+	case '#':
+		return i > 0 && (text[i-1] == ' ' || text[i-1] == '\t')
+	case ':':
+		return i+1 == len(text) || strings.ContainsRune(" ,[]{}", rune(text[i+1]))
+	}
+	return false
+}
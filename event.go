@@ -1,6 +1,9 @@
 package main
 
-import "os"
+import (
+  "os"
+  "time"
+)
 
 type FileEvent struct {
   Source *string `json:"source,omitempty"`
@@ -9,5 +12,24 @@ type FileEvent struct {
   Text   *string `json:"text,omitempty"`
   Fields *map[string]string
 
-  fileinfo *os.FileInfo
+  fileinfo    *os.FileInfo
+  fileconfig  *FileConfig
+  readTime    time.Time        /* when the line was read */
+  timestamp   time.Time        /* from the line if it has one, otherwise readTime */
+  fileSize    int64            /* of the file when the line was read */
+  fingerprint *fileFingerprint /* of the file when the line was read, if asked for */
+  nextOffset  int64            /* where reading resumes after this line */
+  marker      bool             /* carries no line, only moves the registry past dropped ones */
+  exit        bool             /* the end of standard input, exiting once acknowledged */
+  pending     int              /* outputs yet to acknowledge this event */
+}
+
+// The number of events that carry a line to publish, leaving out markers.
+func countLines(events []*FileEvent) (count int) {
+  for _, event := range events {
+    if !event.marker {
+      count++
+    }
+  }
+  return count
 }
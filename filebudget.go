@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// The limit on files harvested at once, if any.
+var openFiles *fileBudget
+
+// Limits how many files are open for harvesting at once. Harvesters over the
+// limit wait for a slot, handed out by the priority of their files entry and
+// then to the most recently modified file.
+type fileBudget struct {
+	sync.Mutex
+	max     int
+	open    int
+	waiting []*budgetWaiter
+}
+
+type budgetWaiter struct {
+	priority int
+	modTime  time.Time
+	ready    chan bool
+}
+
+func newFileBudget(max int) *fileBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &fileBudget{max: max}
+}
+
+// Waits for a slot to open path in.
+func (b *fileBudget) Acquire(path string, priority int) {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	if b.open < b.max {
+		b.open++
+		b.Unlock()
+		return
+	}
+	waiter := &budgetWaiter{priority: priority, ready: make(chan bool, 1)}
+	if info, err := os.Stat(path); err == nil {
+		waiter.modTime = info.ModTime()
+	}
+	b.waiting = append(b.waiting, waiter)
+	countStat("harvesters.queued", 1)
+	emit("Waiting to harvest %s: %d files are open, the most allowed\n", path, b.open)
+	b.Unlock()
+
+	<-waiter.ready
+}
+
+// Frees a slot, handing it straight to the first waiter in line if any.
+func (b *fileBudget) Release() {
+	if b == nil {
+		return
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	if len(b.waiting) == 0 {
+		b.open--
+		return
+	}
+
+	next := 0
+	for i, waiter := range b.waiting {
+		first := b.waiting[next]
+		if waiter.priority > first.priority || waiter.priority == first.priority && waiter.modTime.After(first.modTime) {
+			next = i
+		}
+	}
+	waiter := b.waiting[next]
+	b.waiting = append(b.waiting[:next], b.waiting[next+1:]...)
+	waiter.ready <- true
+}
+
+// Sets the limit on files harvested at once.
+func configureOpenFiles(config *Config) error {
+	if config.MaxOpenFiles < 0 {
+		return fmt.Errorf("Invalid max open files %d, it must not be negative", config.MaxOpenFiles)
+	}
+	openFiles = newFileBudget(config.MaxOpenFiles)
+	return nil
+}
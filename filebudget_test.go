@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestFileBudget(t *testing.T) {
+	if newFileBudget(0) != nil {
+		t.Fatalf("Expected no budget without a limit")
+	}
+
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	older, newer := path.Join(tmpdir, "older.log"), path.Join(tmpdir, "newer.log")
+	chkerr(t, ioutil.WriteFile(older, nil, 0644))
+	chkerr(t, ioutil.WriteFile(newer, nil, 0644))
+	chkerr(t, os.Chtimes(older, time.Now(), time.Now().Add(-time.Hour)))
+
+	budget := newFileBudget(1)
+	budget.Acquire(older, 1)
+
+	acquired := make(chan string, 3)
+	waitFor := func(path string, priority int) {
+		budget.Acquire(path, priority)
+		acquired <- path
+	}
+	waiters := func() int {
+		budget.Lock()
+		defer budget.Unlock()
+		return len(budget.waiting)
+	}
+	go waitFor(older, 1)
+	go waitFor(newer, 1)
+	go waitFor(older, 2)
+	for waiters() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	expected := []string{older, newer, older}
+	for i, path := range expected {
+		budget.Release()
+		if got := <-acquired; got != path {
+			t.Fatalf("Expected slot %d to go to %s, got %s", i, path, got)
+		}
+		if i == 0 && waiters() != 2 {
+			t.Fatalf("Expected the other harvesters to keep waiting")
+		}
+	}
+	budget.Release()
+	if budget.open != 0 {
+		t.Fatalf("Expected every slot to be free, %d are open", budget.open)
+	}
+}
@@ -9,3 +9,10 @@ func file_ids(info *os.FileInfo) (uint64, int32) {
   fstat := (*(info)).Sys().(*syscall.Stat_t)
   return fstat.Ino, fstat.Dev
 }
+
+// Whether the file has been unlinked from every directory, so only open
+// descriptors keep it around.
+func is_file_deleted(info *os.FileInfo) bool {
+  fstat := (*(info)).Sys().(*syscall.Stat_t)
+  return fstat.Nlink == 0
+}
@@ -8,3 +8,9 @@ func file_ids(info *os.FileInfo) (uint64, uint64) {
   // No dev and inode numbers on windows, right?
   return 0, 0
 }
+
+func is_file_deleted(info *os.FileInfo) bool {
+  // Windows doesn't let a file open for reading be deleted, unless opened
+  // with FILE_SHARE_DELETE, which os.Open doesn't do.
+  return false
+}
@@ -1,8 +1,10 @@
 package main
 
 type FileState struct {
-  Source *string `json:"source,omitempty"`
-  Offset int64   `json:"offset,omitempty"`
-  Inode  uint64  `json:"inode,omitempty"`
-  Device int32   `json:"device,omitempty"`
+  Source          *string `json:"source,omitempty"`
+  Offset          int64   `json:"offset,omitempty"`
+  Inode           uint64  `json:"inode,omitempty"`
+  Device          int32   `json:"device,omitempty"`
+  Fingerprint     string  `json:"fingerprint,omitempty"` /* of the first FingerprintSize bytes */
+  FingerprintSize int64   `json:"fingerprint_size,omitempty"`
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// Bytes fingerprinted when the files entry doesn't say
+const defaultFingerprintBytes = 1024
+
+// The most of the last line read kept to check it is still there
+const maxTailBytes = 64
+
+// A hash of the first bytes of a file, which tells a file from another that
+// took over its inode. A file shorter than the bytes asked for is
+// fingerprinted by what it has, and one that is empty only by its inode.
+type fileFingerprint struct {
+	size int64 /* bytes hashed */
+	sum  string
+}
+
+// Fingerprints up to max bytes from the start of file.
+func fingerprintFile(file *os.File, max int64) (*fileFingerprint, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, io.NewSectionReader(file, 0, max))
+	if err != nil {
+		return nil, err
+	}
+	return &fileFingerprint{size: size, sum: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// Whether the file at path still starts with the bytes fingerprinted in
+// state. A state without a fingerprint, or with an empty one, matches any
+// file.
+func fingerprintMatches(path string, state *FileState) bool {
+	if state.Fingerprint == "" || state.FingerprintSize == 0 {
+		return true
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	current, err := fingerprintFile(file, state.FingerprintSize)
+	return err == nil && current.size == state.FingerprintSize && current.sum == state.Fingerprint
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestFingerprint(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(logfile, []byte("short\n"), 0644))
+
+	file, err := os.Open(logfile)
+	chkerr(t, err)
+	fingerprint, err := fingerprintFile(file, 16)
+	file.Close()
+	chkerr(t, err)
+	if fingerprint.size != 6 {
+		t.Fatalf("Expected a short file to be fingerprinted by its 6 bytes, got %d", fingerprint.size)
+	}
+	state := &FileState{Fingerprint: fingerprint.sum, FingerprintSize: fingerprint.size}
+
+	// Growing the file keeps the bytes fingerprinted
+	appendTo, err := os.OpenFile(logfile, os.O_APPEND|os.O_WRONLY, 0644)
+	chkerr(t, err)
+	appendTo.WriteString("and then some more\n")
+	appendTo.Close()
+	if !fingerprintMatches(logfile, state) {
+		t.Fatalf("Expected a grown file to match its fingerprint")
+	}
+
+	chkerr(t, ioutil.WriteFile(logfile, []byte("other\nlines\n"), 0644))
+	if fingerprintMatches(logfile, state) {
+		t.Fatalf("Expected a file with other content not to match")
+	}
+	chkerr(t, ioutil.WriteFile(logfile, []byte("sho"), 0644))
+	if fingerprintMatches(logfile, state) {
+		t.Fatalf("Expected a file shorter than the fingerprint not to match")
+	}
+	if !fingerprintMatches(logfile, &FileState{}) || !fingerprintMatches(logfile, &FileState{Fingerprint: "e3b0", FingerprintSize: 0}) {
+		t.Fatalf("Expected a state without a fingerprint to match any file")
+	}
+
+	p := &Prospector{FileConfig: FileConfig{}}
+	if !p.is_content_same(logfile, state) {
+		t.Fatalf("Expected content not to be compared without fingerprint bytes")
+	}
+}
@@ -5,7 +5,11 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os" // for File and friends
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,18 +17,29 @@ type Harvester struct {
 	Path       string /* the file path to harvest */
 	FileConfig FileConfig
 	Offset     int64
+	Start      *startPosition /* where to start instead of Offset, for a file without state */
+	Info       os.FileInfo    /* of the file at Path when launched, if known */
 	FinishChan chan int64
 
-	file *os.File /* the file being watched */
+	file        *os.File /* the file being watched */
+	original    *os.File /* while reading the copy of a truncated file, the file */
+	fingerprint *fileFingerprint
+	tail        string /* the end of the last line read, without its newline */
+	tailEOL     int    /* the length of that newline, 0 before any line is read */
 }
 
 func (h *Harvester) Harvest(output chan *FileEvent) {
-	h.open()
+	openFiles.Acquire(h.Path, h.FileConfig.Priority)
+	defer openFiles.Release()
+	if h.open() == nil {
+		h.FinishChan <- h.Offset
+		return
+	}
 	info, e := h.file.Stat()
 	if e != nil {
 		panic(fmt.Sprintf("Harvest: unexpected error: %s", e.Error()))
 	}
-	defer h.file.Close()
+	defer h.close()
 
 	// On completion, push offset so we can continue where we left off if we relaunch on the same file
 	defer func() { h.FinishChan <- h.Offset }()
@@ -36,72 +51,401 @@ func (h *Harvester) Harvest(output chan *FileEvent) {
 
 	if h.Offset > 0 {
 		emit("harvest: %q position:%d (offset snapshot:%d)\n", h.Path, h.Offset, offset)
-	} else if options.tailOnRotate {
-		emit("harvest: (tailing) %q (offset snapshot:%d)\n", h.Path, offset)
+	} else if h.Start != nil {
+		emit("harvest: %q from %s (offset snapshot:%d)\n", h.Path, h.Start.name, offset)
 	} else {
 		emit("harvest: %q (offset snapshot:%d)\n", h.Path, offset)
 	}
 
 	h.Offset = offset
+	h.updateFingerprint()
 
 	reader := bufio.NewReaderSize(h.file, options.harvesterBufferSize) // 16kb buffer by default
 	buffer := new(bytes.Buffer)
 
 	var read_timeout = 10 * time.Second
 	last_read_time := time.Now()
+	size := info.Size()
+	var dropped uint64 // lines filtered out since the last event shipped
+
+	// Lines suppressed by a rate limit are summed up in an event every
+	// summary interval of the limit.
+	var suppressed uint64
+	var summary_interval time.Duration
+	last_summary_time := time.Now()
+	summarize := func() {
+		if suppressed > 0 && time.Since(last_summary_time) >= summary_interval {
+			output <- h.summary(&info, suppressed)
+			suppressed, dropped = 0, 0
+			last_summary_time = time.Now()
+		}
+	}
+
+	// When the file was last looked at while reading it, and first seen
+	// deleted
+	var last_stat_time, deleted_time time.Time
+
 	for {
 		text, bytesread, err := h.readline(reader, buffer, read_timeout)
 
 		if err != nil {
 			if err == io.EOF {
+				summarize()
+				if dropped > 0 {
+					// Nothing after the dropped lines moves the registry past them,
+					// so send a marker that does.
+					output <- h.marker(&info)
+					dropped = 0
+				}
+
+				if h.original != nil {
+					// The copy of a truncated file has been read, so carry on
+					// from the start of the file itself.
+					emit("Finished reading the copy of %s, seeking to beginning\n", h.Path)
+					h.file.Close()
+					h.file, h.original = h.original, nil
+					h.rewind(reader, buffer)
+					if info, err := h.file.Stat(); err == nil {
+						size = info.Size()
+					}
+					continue
+				}
+
 				// timed out waiting for data, got eof.
-				// Check to see if the file was truncated
+				// Check to see if the file was truncated: it is smaller than
+				// when last seen, or no longer has the line just read before
+				// the offset, having grown back past it.
 				info, _ := h.file.Stat()
-				if info.Size() < h.Offset {
-					emit("File truncated, seeking to beginning: %s\n", h.Path)
-					h.file.Seek(0, os.SEEK_SET)
-					h.Offset = 0
+				truncated := info.Size() < h.Offset || info.Size() < size || !h.tailMatches(h.file)
+				size = info.Size()
+				if truncated {
+					h.truncated(reader, buffer)
+				} else if is_file_deleted(&info) {
+					// Nothing can reopen a deleted file, so once it's read to the
+					// end let its disk space go.
+					emit("Stopping harvest of %s; it was deleted and has been read to the end\n", h.Path)
+					return
 				} else if age := time.Since(last_read_time); age > h.FileConfig.deadtime {
 					// if last_read_time was more than dead time, this file is probably
 					// dead. Stop watching it.
 					emit("Stopping harvest of %s; last change was %v ago\n", h.Path, age)
 					return
+				} else if h.FileConfig.closeInactive > 0 && age > h.FileConfig.closeInactive && h.rotatedAway(info) {
+					// Nothing more will be written to a rotated or deleted file
+					// once its writer has moved on, so free its descriptor.
+					emit("Stopping harvest of %s; it was rotated away or deleted, last change was %v ago\n", h.Path, age)
+					return
 				}
 				continue
 			} else {
 				emit("Unexpected state reading from %s; error: %s\n", h.Path, err)
+				if info, err := h.file.Stat(); err == nil {
+					h.abandon(info.Size(), "of the error")
+				}
 				return
 			}
 		}
 		last_read_time = time.Now()
 
+		// While reading a backlog, look at the file now and then for it
+		// shrinking under us, or being deleted.
+		if last_read_time.Sub(last_stat_time) >= time.Second {
+			last_stat_time = last_read_time
+			if info, err := h.file.Stat(); err == nil {
+				if info.Size() < size {
+					// The line just read may be from before or after the
+					// truncation, so it is read again from wherever it is.
+					h.truncated(reader, buffer)
+					size = info.Size()
+					continue
+				}
+
+				// A deleted file still being read after close deleted is given
+				// up on, so its disk space isn't held for as long as the
+				// backlog takes.
+				if h.FileConfig.closeDeleted > 0 && is_file_deleted(&info) {
+					if deleted_time.IsZero() {
+						deleted_time = last_read_time
+					}
+					if last_read_time.Sub(deleted_time) > h.FileConfig.closeDeleted {
+						h.abandon(info.Size(), "it was deleted over "+h.FileConfig.CloseDeleted+" ago")
+						return
+					}
+				}
+			}
+		}
+		h.tail, h.tailEOL = *text, bytesread-len(*text)
+		if len(h.tail) > maxTailBytes {
+			h.tail = h.tail[len(h.tail)-maxTailBytes:]
+		}
+		if h.Offset+int64(bytesread) > size {
+			size = h.Offset + int64(bytesread)
+		}
+
 		line++
-		event := &FileEvent{
-			Source:   &h.Path,
-			Offset:   h.Offset,
-			Line:     line,
-			Text:     text,
-			Fields:   &h.FileConfig.Fields,
-			fileinfo: &info,
+		if h.FileConfig.filter != nil && !h.FileConfig.filter.Keep(*text) {
+			h.Offset += int64(bytesread)
+			countStat("file."+h.Path+".lines.dropped", 1)
+			if dropped++; dropped >= options.spoolSize {
+				output <- h.marker(&info)
+				dropped = 0
+			}
+			continue
+		}
+		if limiter := h.limit(bytesread); limiter != nil {
+			h.Offset += int64(bytesread)
+			countStat("file."+h.Path+".lines.suppressed", 1)
+			suppressed++
+			summary_interval = limiter.interval
+			if dropped++; dropped >= options.spoolSize {
+				output <- h.marker(&info)
+				dropped = 0
+			}
+			summarize()
+			continue
 		}
+		summarize()
+		dropped = 0
+
+		if h.fingerprint != nil && h.fingerprint.size < h.fingerprintBytes() && size > h.fingerprint.size {
+			// Until the file has grown to the bytes fingerprinted, its
+			// fingerprint grows along with it
+			h.updateFingerprint()
+		}
+
+		event := h.event(text, line, &info, last_read_time, size)
 		h.Offset += int64(bytesread)
+		event.nextOffset = h.Offset
 
 		output <- event // ship the new event downstream
 	} /* forever */
 }
 
-func (h *Harvester) open() *os.File {
-	// Special handling that "-" means to read from standard input
-	if h.Path == "-" {
-		h.file = os.Stdin
-		return h.file
+// Fingerprints the start of the file, to record in the registry if the
+// files entry asks for it, and to find its copy after a copytruncate
+// rotation. A file that can't be read keeps its previous fingerprint.
+func (h *Harvester) updateFingerprint() {
+	fingerprint, err := fingerprintFile(h.file, h.fingerprintBytes())
+	if err != nil {
+		emit("Failed to fingerprint %s: %s\n", h.Path, err)
+		return
+	}
+	h.fingerprint = fingerprint
+}
+
+func (h *Harvester) fingerprintBytes() int64 {
+	if h.FileConfig.FingerprintBytes > 0 {
+		return h.FileConfig.FingerprintBytes
+	}
+	return defaultFingerprintBytes
+}
+
+// The fingerprint to record in the registry, if the files entry asks for one.
+func (h *Harvester) recordedFingerprint() *fileFingerprint {
+	if h.FileConfig.FingerprintBytes > 0 {
+		return h.fingerprint
+	}
+	return nil
+}
+
+// Whether file still has the end of the last line read just before the
+// offset.
+func (h *Harvester) tailMatches(file *os.File) bool {
+	if h.tailEOL == 0 {
+		return true
+	}
+	expected := h.tail + "\r\n"[2-h.tailEOL:]
+	if h.Offset < int64(len(expected)) {
+		return false
+	}
+	found := make([]byte, len(expected))
+	if _, err := file.ReadAt(found, h.Offset-int64(len(found))); err != nil {
+		return false
+	}
+	return string(found) == expected
+}
+
+// Carries on after the file was truncated under the harvester. With
+// copytruncate rotation, the lines past the offset may only be in the copy,
+// so those are read from the copy first if it can be found. Otherwise the
+// file is read again from the start.
+func (h *Harvester) truncated(reader *bufio.Reader, buffer *bytes.Buffer) {
+	if copy := h.findCopy(); copy != nil {
+		emit("File truncated, reading the rest of it from its copy %s: %s\n", copy.Name(), h.Path)
+		countStat("file."+h.Path+".truncations.copied", 1)
+		copy.Seek(h.Offset, os.SEEK_SET)
+		h.file, h.original = copy, h.file
+		reader.Reset(h.file)
+		buffer.Reset()
+		return
 	}
 
+	emit("File truncated, seeking to beginning: %s\n", h.Path)
+	countStat("file."+h.Path+".truncations", 1)
+	h.rewind(reader, buffer)
+}
+
+// Closes the file being read, and while reading the copy of a truncated
+// file, the file itself.
+func (h *Harvester) close() {
+	h.file.Close()
+	if h.original != nil {
+		h.original.Close()
+		h.original = nil
+	}
+}
+
+func (h *Harvester) rewind(reader *bufio.Reader, buffer *bytes.Buffer) {
+	h.file.Seek(0, os.SEEK_SET)
+	h.Offset = 0
+	h.tail, h.tailEOL = "", 0
+	h.updateFingerprint()
+	reader.Reset(h.file)
+	buffer.Reset()
+}
+
+// Finds the copy a copytruncate rotation made of the file before truncating
+// it: the most recently modified file beside it whose name starts with its
+// name, which starts with the bytes the file did and has the line read last
+// just before the offset.
+func (h *Harvester) findCopy() *os.File {
+	if h.fingerprint == nil || h.fingerprint.size == 0 {
+		return nil
+	}
+	dir, name := filepath.Split(h.Path)
+	infos, err := ioutil.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil
+	}
+
+	var found *os.File
+	var foundTime time.Time
+	state := &FileState{Fingerprint: h.fingerprint.sum, FingerprintSize: h.fingerprint.size}
+	for _, info := range infos {
+		if info.Name() == name || !strings.HasPrefix(info.Name(), name) || !info.Mode().IsRegular() ||
+			info.Size() <= h.Offset || (found != nil && !info.ModTime().After(foundTime)) {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		if !fingerprintMatches(path, state) {
+			continue
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if !h.tailMatches(file) {
+			file.Close()
+			continue
+		}
+		if found != nil {
+			found.Close()
+		}
+		found, foundTime = file, info.ModTime()
+	}
+	return found
+}
+
+// Logs and counts the bytes left unread when stopping before the end of a
+// file of size bytes.
+func (h *Harvester) abandon(size int64, reason string) {
+	abandoned := size - h.Offset
+	if abandoned < 0 {
+		abandoned = 0
+	}
+	emit("Stopping harvest of %s with %d bytes unread, because %s\n", h.Path, abandoned, reason)
+	countStat("file."+h.Path+".bytes.abandoned", uint64(abandoned))
+}
+
+// Whether the path harvested no longer leads to the open file, which was
+// renamed or deleted.
+func (h *Harvester) rotatedAway(info os.FileInfo) bool {
+	current, err := os.Stat(h.Path)
+	return err != nil || !os.SameFile(info, current)
+}
+
+// The rate limiter that won't let a line of size bytes through, of the file
+// entry or of all files, or nil if both do.
+func (h *Harvester) limit(size int) *rateLimiter {
+	return allowLine(size, h.FileConfig.limiter, globalLimiter)
+}
+
+// An event shipping the line read at readTime, with the timestamp parsed
+// from it and the processors of the files entry run on it.
+func (h *Harvester) event(text *string, line uint64, info *os.FileInfo, readTime time.Time, size int64) *FileEvent {
+	event := &FileEvent{
+		Source:      &h.Path,
+		Offset:      h.Offset,
+		Line:        line,
+		Text:        text,
+		Fields:      &h.FileConfig.fields,
+		fileinfo:    info,
+		fileconfig:  &h.FileConfig,
+		readTime:    readTime,
+		timestamp:   readTime,
+		fileSize:    size,
+		fingerprint: h.recordedFingerprint(),
+	}
+	if h.FileConfig.timestamp != nil {
+		if timestamp, ok := h.FileConfig.timestamp.Parse(*text, readTime); ok {
+			event.timestamp = timestamp
+		} else {
+			countStat("file."+h.Path+".timestamps.unparsed", 1)
+		}
+	}
+	if h.FileConfig.processors != nil {
+		if failed := h.FileConfig.processors.Process(event); failed > 0 {
+			countStat("file."+h.Path+".processors.failed", uint64(failed))
+		}
+	}
+	return event
+}
+
+// An event saying how many lines a rate limit suppressed, which like a
+// marker records the current offset in the registry.
+func (h *Harvester) summary(info *os.FileInfo, suppressed uint64) *FileEvent {
+	text := fmt.Sprintf("Suppressed %d lines from %s over a rate limit", suppressed, h.Path)
+	fields := make(map[string]string, len(h.FileConfig.fields)+2)
+	for name, value := range h.FileConfig.fields {
+		fields[name] = value
+	}
+	fields["suppressed_lines"] = strconv.FormatUint(suppressed, 10)
+	addTag(fields, "_ratelimited")
+
+	event := h.marker(info)
+	event.marker = false
+	event.Text = &text
+	event.Fields = &fields
+	event.readTime = time.Now()
+	event.timestamp = event.readTime
+	return event
+}
+
+// An event shipping no line, which records the current offset in the
+// registry once it has passed through every output.
+func (h *Harvester) marker(info *os.FileInfo) *FileEvent {
+	return &FileEvent{
+		Source:      &h.Path,
+		Offset:      h.Offset,
+		Fields:      &h.FileConfig.fields,
+		fileinfo:    info,
+		fileconfig:  &h.FileConfig,
+		nextOffset:  h.Offset,
+		marker:      true,
+		fingerprint: h.recordedFingerprint(),
+	}
+}
+
+// Opens the file and seeks to where harvesting starts. A harvester may wait
+// a long time for a slot to open its file in, and if the file it was
+// launched on has been rotated away meanwhile, it follows it, as it would
+// have once open. Returns nil if that file can't be found.
+func (h *Harvester) open() *os.File {
 	for {
 		var err error
 		h.file, err = os.Open(h.Path)
 
-		if err != nil {
+		if err != nil && !(os.IsNotExist(err) && h.Info != nil) {
 			// retry on failure.
 			emit("Failed opening %s: %s\n", h.Path, err)
 			time.Sleep(5 * time.Second)
@@ -110,20 +454,65 @@ func (h *Harvester) open() *os.File {
 		}
 	}
 
+	if h.Info != nil && !h.isLaunchFile(h.file) {
+		if h.file != nil {
+			h.file.Close()
+		}
+		if h.file = h.findRotated(); h.file == nil {
+			emit("Stopping harvest of %s; it was rotated away and removed before it could be opened\n", h.Path)
+			return nil
+		}
+		emit("Harvesting %s from %s, where it was rotated to before it could be opened\n", h.Path, h.file.Name())
+	}
+
 	// Check we are not following a rabbit hole (symlinks, etc.)
 	mustBeRegularFile(h.file) // panics
 
-	if h.Offset > 0 {
-		h.file.Seek(h.Offset, os.SEEK_SET)
-	} else if options.tailOnRotate {
-		h.file.Seek(0, os.SEEK_END)
+	if h.Start != nil {
+		offset, err := h.Start.find(h.file)
+		if err != nil {
+			emit("Failed to find the %s of %s, starting at the beginning: %s\n", h.Start.name, h.Path, err)
+		}
+		h.file.Seek(offset, os.SEEK_SET)
 	} else {
-		h.file.Seek(0, os.SEEK_SET)
+		h.file.Seek(h.Offset, os.SEEK_SET)
 	}
 
 	return h.file
 }
 
+// Whether file is the one the harvester was launched on.
+func (h *Harvester) isLaunchFile(file *os.File) bool {
+	if file == nil {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && os.SameFile(info, h.Info)
+}
+
+// Opens the file the harvester was launched on where it was rotated to,
+// looking beside its path, or returns nil if it isn't there.
+func (h *Harvester) findRotated() *os.File {
+	dir := filepath.Dir(h.Path)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !os.SameFile(entry, h.Info) {
+			continue
+		}
+		file, err := os.Open(filepath.Join(dir, entry.Name()))
+		if err == nil && h.isLaunchFile(file) {
+			return file
+		}
+		if file != nil {
+			file.Close()
+		}
+	}
+	return nil
+}
+
 func (h *Harvester) readline(reader *bufio.Reader, buffer *bytes.Buffer, eof_timeout time.Duration) (*string, int, error) {
 	var is_partial bool = true
 	var newline_length int = 1
@@ -174,8 +563,6 @@ func (h *Harvester) readline(reader *bufio.Reader, buffer *bytes.Buffer, eof_tim
 			return str, bufferSize, nil
 		}
 	} /* forever read chunks */
-
-	return nil, 0, nil
 }
 
 // panics
@@ -189,6 +576,6 @@ func mustBeRegularFile(f *os.File) {
 	}
 
 	if !info.Mode().IsRegular() {
-		panic(fmt.Errorf("Harvester: not a regular file:%q", info.Mode(), info.Name()))
+		panic(fmt.Errorf("Harvester: not a regular file:%q (%s)", info.Name(), info.Mode()))
 	}
 }
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestHarvesterRotatedAway(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(logfile, []byte("line\n"), 0644))
+
+	h := &Harvester{Path: logfile}
+	h.open()
+	defer h.file.Close()
+	info, err := h.file.Stat()
+	chkerr(t, err)
+
+	if h.rotatedAway(info) {
+		t.Fatalf("Expected the file not to be rotated away yet")
+	}
+	chkerr(t, os.Rename(logfile, logfile+".1"))
+	if !h.rotatedAway(info) {
+		t.Fatalf("Expected a renamed file to be rotated away")
+	}
+	chkerr(t, ioutil.WriteFile(logfile, []byte("new\n"), 0644))
+	if !h.rotatedAway(info) {
+		t.Fatalf("Expected a file replaced at its path to be rotated away")
+	}
+}
+
+func TestIsFileDeleted(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(logfile, []byte("line\n"), 0644))
+
+	file, err := os.Open(logfile)
+	chkerr(t, err)
+	defer file.Close()
+
+	info, err := file.Stat()
+	chkerr(t, err)
+	if is_file_deleted(&info) {
+		t.Fatalf("Expected the file not to be deleted yet")
+	}
+	chkerr(t, os.Remove(logfile))
+	info, err = file.Stat()
+	chkerr(t, err)
+	if !is_file_deleted(&info) {
+		t.Fatalf("Expected the open file to be deleted")
+	}
+}
+
+func TestHarvesterAbandon(t *testing.T) {
+	h := &Harvester{Path: "/var/log/abandoned.log", Offset: 100}
+	before := readStat("file./var/log/abandoned.log.bytes.abandoned")
+	h.abandon(250, "it was deleted")
+	if abandoned := readStat("file./var/log/abandoned.log.bytes.abandoned") - before; abandoned != 150 {
+		t.Fatalf("Expected 150 bytes to be abandoned, got %d", abandoned)
+	}
+}
+
+func TestHarvesterCopyTruncate(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(logfile, []byte("first line\r\nsecond line\n"), 0644))
+
+	h := &Harvester{Path: logfile}
+	h.open()
+	defer h.file.Close()
+	h.updateFingerprint()
+	h.Offset, h.tail, h.tailEOL = 12, "first line", 2
+	if !h.tailMatches(h.file) {
+		t.Fatalf("Expected the line read to be before the offset")
+	}
+
+	// copytruncate, and the file grows back past the offset
+	chkerr(t, ioutil.WriteFile(logfile+".1", []byte("first line\r\nsecond line\n"), 0644))
+	chkerr(t, ioutil.WriteFile(logfile+".old", []byte("first line\r\nsomething else\n"), 0644))
+	chkerr(t, os.Truncate(logfile, 0))
+	chkerr(t, ioutil.WriteFile(logfile, []byte("a new line that is longer\n"), 0644))
+	if h.tailMatches(h.file) {
+		t.Fatalf("Expected the regrown file not to have the line read before the offset")
+	}
+
+	reader := bufio.NewReader(h.file)
+	buffer := new(bytes.Buffer)
+	original := h.file
+	h.truncated(reader, buffer)
+	if h.original != original || h.file.Name() != logfile+".1" {
+		t.Fatalf("Expected to read on from the copy, got %s", h.file.Name())
+	}
+	rest, err := reader.ReadString('\n')
+	chkerr(t, err)
+	if rest != "second line\n" || h.Offset != 12 {
+		t.Fatalf("Expected the copy to be read from the offset, got %q at %d", rest, h.Offset)
+	}
+	h.file.Close()
+
+	// Without a copy, the file is read again from the start
+	h.file, h.original = original, nil
+	chkerr(t, os.Remove(logfile+".1"))
+	h.truncated(reader, buffer)
+	line, err := reader.ReadString('\n')
+	chkerr(t, err)
+	if line != "a new line that is longer\n" || h.Offset != 0 || h.tailEOL != 0 {
+		t.Fatalf("Expected the file to be read from the start, got %q at %d", line, h.Offset)
+	}
+}
+
+func TestHarvesterCloseReadingCopy(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(logfile, []byte("first line\nsecond line\n"), 0644))
+
+	h := &Harvester{Path: logfile}
+	h.open()
+	h.updateFingerprint()
+	h.Offset, h.tail, h.tailEOL = 11, "first line", 1
+
+	chkerr(t, ioutil.WriteFile(logfile+".1", []byte("first line\nsecond line\n"), 0644))
+	chkerr(t, os.Truncate(logfile, 0))
+	original := h.file
+	h.truncated(bufio.NewReader(h.file), new(bytes.Buffer))
+	if h.original != original {
+		t.Fatalf("Expected to read on from the copy")
+	}
+
+	// Stopping before the copy is read to the end, as when it is deleted
+	// over close deleted ago, closes both
+	copy := h.file
+	h.close()
+	for _, file := range []*os.File{copy, original} {
+		if _, err := file.Stat(); err == nil {
+			t.Errorf("Expected %s to be closed", file.Name())
+		}
+	}
+	if h.original != nil {
+		t.Errorf("Expected no file to be left to read on from")
+	}
+}
+
+func TestHarvesterRotatedWhileQueued(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(logfile, []byte("skipped\nold line\n"), 0644))
+	info, err := os.Stat(logfile)
+	chkerr(t, err)
+
+	defer func(budget *fileBudget) { openFiles = budget }(openFiles)
+	openFiles = newFileBudget(1)
+	openFiles.Acquire("/var/log/other.log", 1)
+
+	output := make(chan *FileEvent, 1)
+	h := &Harvester{Path: logfile, Offset: 8, Info: info, FinishChan: make(chan int64, 1)}
+	go h.Harvest(output)
+	for {
+		openFiles.Lock()
+		queued := len(openFiles.waiting)
+		openFiles.Unlock()
+		if queued > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Rotated while the harvester waits for a slot
+	chkerr(t, os.Rename(logfile, logfile+".1"))
+	chkerr(t, ioutil.WriteFile(logfile, []byte("new line\n"), 0644))
+	openFiles.Release()
+
+	select {
+	case event := <-output:
+		if *event.Text != "old line" || event.Offset != 8 {
+			t.Fatalf("Expected the rotated file to be read from the offset, got %q at %d", *event.Text, event.Offset)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the rotated file to be harvested")
+	}
+
+	// Once the rotated file is gone too, there is nothing to harvest
+	chkerr(t, os.Remove(logfile+".1"))
+	h = &Harvester{Path: logfile, Info: info}
+	if h.open() != nil {
+		t.Fatalf("Expected the harvester not to open the new file at %s", logfile)
+	}
+}
@@ -135,6 +135,7 @@ module Lumberjack
     FRAME_WINDOW = "W".ord
     FRAME_DATA = "D".ord
     FRAME_COMPRESSED = "C".ord
+    FRAME_LZ4 = "L".ord
     def header(&block)
       version, frame_type = get.bytes.to_a[0..1]
 
@@ -142,6 +143,7 @@ module Lumberjack
         when FRAME_WINDOW; transition(:window_size, 4)
         when FRAME_DATA; transition(:data_lead, 8)
         when FRAME_COMPRESSED; transition(:compressed_lead, 4)
+        when FRAME_LZ4; transition(:lz4_lead, 8)
         else; raise "Unknown frame type: #{frame_type}"
       end
     end
@@ -200,6 +202,55 @@ module Lumberjack
       # Parse the uncompressed payload.
       feed(original, &block)
     end
+
+    def lz4_lead(&block)
+      @lz4_length, length = get.unpack("NN")
+      transition(:lz4_payload, length)
+    end
+
+    def lz4_payload(&block)
+      original = lz4_decompress(get, @lz4_length)
+      transition(:header, 2)
+
+      # Parse the uncompressed payload.
+      feed(original, &block)
+    end
+
+    # Decompress an LZ4 block (no frame headers or checksums).
+    def lz4_decompress(input, length)
+      input = input.bytes.to_a
+      output = []
+      i = 0
+      while i < input.size
+        token = input[i]; i += 1
+
+        literals = token >> 4
+        if literals == 15
+          begin
+            byte = input[i]; i += 1
+            literals += byte
+          end while byte == 255
+        end
+        output.concat(input[i, literals]); i += literals
+        break if i >= input.size
+
+        offset = input[i] | (input[i + 1] << 8); i += 2
+        match = token & 15
+        if match == 15
+          begin
+            byte = input[i]; i += 1
+            match += byte
+          end while byte == 255
+        end
+        start = output.size - offset
+        (match + 4).times { |n| output << output[start + n] }
+      end
+
+      if output.size != length
+        raise "LZ4 payload decompressed to #{output.size} bytes, expected #{length}"
+      end
+      return output.pack("C*")
+    end # def lz4_decompress
   end # class Parser
 
   class Connection
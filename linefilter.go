@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Decides which lines of a file are shipped. With "include lines" only lines
+// matching one of them are kept; any line matching one of "exclude lines"
+// is dropped, even if it is also included.
+type lineFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// Builds the line filter for a file entry, or nil if it ships every line.
+func newLineFilter(fileconfig *FileConfig) (*lineFilter, error) {
+	if len(fileconfig.IncludeLines) == 0 && len(fileconfig.ExcludeLines) == 0 {
+		return nil, nil
+	}
+
+	filter := &lineFilter{}
+	var err error
+	if filter.include, err = compileLinePatterns(fileconfig.IncludeLines); err != nil {
+		return nil, settingErrorf("include lines", "invalid include lines pattern %s", err)
+	}
+	if filter.exclude, err = compileLinePatterns(fileconfig.ExcludeLines); err != nil {
+		return nil, settingErrorf("exclude lines", "invalid exclude lines pattern %s", err)
+	}
+	return filter, nil
+}
+
+func compileLinePatterns(patterns []string) (compiled []*regexp.Regexp, err error) {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+func (f *lineFilter) Keep(line string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(line) {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
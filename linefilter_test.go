@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLineFilter(t *testing.T) {
+	filter, err := newLineFilter(&FileConfig{})
+	chkerr(t, err)
+	if filter != nil {
+		t.Fatalf("Expected no filter without patterns, got %v", filter)
+	}
+
+	filter, err = newLineFilter(&FileConfig{
+		IncludeLines: []string{`^ERROR`, `^WARN`},
+		ExcludeLines: []string{`/healthz`},
+	})
+	chkerr(t, err)
+	for line, keep := range map[string]bool{
+		"ERROR disk full":            true,
+		"WARN slow request /api":     true,
+		"INFO started":               false,
+		"WARN slow request /healthz": false,
+		"GET /healthz 200 0.1ms":     false,
+		"":                           false,
+	} {
+		if filter.Keep(line) != keep {
+			t.Errorf("Expected keeping %q to be %t", line, keep)
+		}
+	}
+
+	filter, err = newLineFilter(&FileConfig{ExcludeLines: []string{`/healthz`}})
+	chkerr(t, err)
+	if !filter.Keep("INFO started") || filter.Keep("GET /healthz") {
+		t.Errorf("Expected only excluded lines to be dropped without include lines")
+	}
+
+	if _, err := newLineFilter(&FileConfig{ExcludeLines: []string{`(`}}); err == nil {
+		t.Errorf("Expected an invalid pattern to be an error")
+	}
+}
+
+func TestMarkersAreNotPublished(t *testing.T) {
+	hostname = "myhost"
+	event := testEvent("/var/log/a.log", "kept", 0, map[string]string{})
+	marker := &FileEvent{Source: event.Source, Offset: 40, nextOffset: 40, marker: true}
+	events := []*FileEvent{event, marker}
+
+	if countLines(events) != 1 {
+		t.Fatalf("Expected 1 line, got %d", countLines(events))
+	}
+	if dataFrameSize(marker) != 0 {
+		t.Fatalf("Expected a marker to take no space in a payload")
+	}
+
+	var lines, expected bytes.Buffer
+	chkerr(t, writeJSONLines(events, &lines))
+	chkerr(t, writeJSONLines(events[:1], &expected))
+	if lines.String() != expected.String() {
+		t.Fatalf("Expected the marker to be left out, got %q", lines.String())
+	}
+
+	var sequence uint32
+	var payload bytes.Buffer
+	chkerr(t, writePayload(events, &sequence, &NetworkConfig{Compression: "none"}, &payload))
+	if sequence != 1 {
+		t.Fatalf("Expected only the line to take a sequence number, got %d", sequence)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"runtime/pprof"
@@ -23,13 +24,16 @@ var options = &struct {
 	harvesterBufferSize int
 	cpuProfileFile      string
 	idleTimeout         time.Duration
+	statsInterval       time.Duration
 	useSyslog           bool
 	tailOnRotate        bool
 	quiet               bool
+	configTest          bool
 }{
 	spoolSize:           1024,
 	harvesterBufferSize: 16 << 10,
 	idleTimeout:         time.Second * 5,
+	statsInterval:       time.Minute,
 }
 
 func emitOptions() {
@@ -38,6 +42,7 @@ func emitOptions() {
 	emit("\tidle-timeout:        %v\n", options.idleTimeout)
 	emit("\tspool-size:          %d\n", options.spoolSize)
 	emit("\tharvester-buff-size: %d\n", options.harvesterBufferSize)
+	emit("\tstats-interval:      %v\n", options.statsInterval)
 	emit("\t--- flags ---------\n")
 	emit("\ttail (on-rotation):  %t\n", options.tailOnRotate)
 	emit("\tlog-to-syslog:          %t\n", options.useSyslog)
@@ -59,7 +64,7 @@ func assertRequiredOptions() {
 const logflags = log.Ldate | log.Ltime | log.Lmicroseconds
 
 func init() {
-	flag.StringVar(&options.configArg, "config", options.configArg, "path to logstash-forwarder configuration file or directory")
+	flag.StringVar(&options.configArg, "config", options.configArg, "path to logstash-forwarder configuration file (json, yaml or toml) or directory")
 
 	flag.StringVar(&options.cpuProfileFile, "cpuprofile", options.cpuProfileFile, "path to cpu profile output - note: exits on profile end.")
 
@@ -69,13 +74,17 @@ func init() {
 	flag.IntVar(&options.harvesterBufferSize, "harvest-buffer-size", options.harvesterBufferSize, "harvester reader buffer size")
 	flag.IntVar(&options.harvesterBufferSize, "hb", options.harvesterBufferSize, "harvester reader buffer size")
 
+	flag.DurationVar(&options.statsInterval, "stats-interval", options.statsInterval, "how often to log statistics such as bytes published, 0 to disable")
+
 	flag.BoolVar(&options.useSyslog, "log-to-syslog", options.useSyslog, "log to syslog instead of stdout") // deprecate this
 	flag.BoolVar(&options.useSyslog, "syslog", options.useSyslog, "log to syslog instead of stdout")
 
-	flag.BoolVar(&options.tailOnRotate, "tail", options.tailOnRotate, "always tail on log rotation -note: may skip entries ")
-	flag.BoolVar(&options.tailOnRotate, "t", options.tailOnRotate, "always tail on log rotation -note: may skip entries ")
+	flag.BoolVar(&options.tailOnRotate, "tail", options.tailOnRotate, "start files without a saved position at the end, unless their files entry sets a start position -note: may skip entries ")
+	flag.BoolVar(&options.tailOnRotate, "t", options.tailOnRotate, "start files without a saved position at the end, unless their files entry sets a start position -note: may skip entries ")
 
 	flag.BoolVar(&options.quiet, "quiet", options.quiet, "operate in quiet mode - only emit errors to log")
+
+	flag.BoolVar(&options.configTest, "configtest", options.configTest, "check the configuration, report any problems and exit")
 }
 
 func init() {
@@ -99,6 +108,11 @@ func main() {
 	}
 
 	assertRequiredOptions()
+
+	if options.configTest {
+		configTest()
+	}
+
 	emitOptions()
 
 	if runProfiler() {
@@ -124,29 +138,56 @@ func main() {
 
 	for _, filename := range config_files {
 		additional_config, err := LoadConfig(filename)
-		if err == nil {
-			err = MergeConfig(&config, additional_config)
-		}
 		if err != nil {
 			fault("Could not load config file %s: %s", filename, err)
 		}
+		MergeConfig(&config, additional_config)
+	}
+	if err := FinalizeConfig(&config); err != nil {
+		fault("%s", err)
+	}
+	if err := configureHostname(&config); err != nil {
+		fault("%s", err)
+	}
+	if err := configureRateLimit(&config); err != nil {
+		fault("%s", err)
+	}
+	if err := configureOpenFiles(&config); err != nil {
+		fault("%s", err)
 	}
-	FinalizeConfig(&config)
 
-	event_chan := make(chan *FileEvent, 16)
-	publisher_chan := make(chan []*FileEvent, 1)
 	registrar_chan := make(chan []*FileEvent, 1)
 
 	if len(config.Files) == 0 {
 		log.Fatalf("No paths given. What files do you want me to watch?\n")
 	}
 
+	// Only the outputs that some file routes to are started
+	routes := make(map[string]chan *FileEvent)
+	for _, fileconfig := range config.Files {
+		for _, name := range fileconfig.Outputs {
+			output, exists := config.Outputs[name]
+			if !exists {
+				fault("Unknown output '%s' for paths %v", name, fileconfig.Paths)
+			}
+			if err := CheckOutputConfig(&output); err != nil {
+				fault("Invalid output '%s': %s", name, err)
+			}
+			routes[name] = make(chan *FileEvent, 16)
+		}
+	}
+
 	// The basic model of execution:
 	// - prospector: finds files in paths/globs to harvest, starts harvesters
-	// - harvester: reads a file, sends events to the spooler
+	// - harvester: reads a file, sends events to the scheduler
+	// - scheduler: takes events from each files entry in turn, by priority,
+	//   and sends them to the router
+	// - router: sends each event to every output its file entry goes to,
+	//   where a scheduler takes from the entries in turn by priority
 	// - spooler: buffers events until ready to flush to the publisher
 	// - publisher: writes to the network, notifies registrar
-	// - registrar: records positions of files read
+	// - registrar: records positions of files read, once all outputs
+	//   of an event have acknowledged it
 	// Finally, prospector uses the registrar information, on restart, to
 	// determine where in each file to restart a harvester.
 
@@ -169,10 +210,13 @@ func main() {
 
 	pendingProspectorCnt := 0
 
-	// Prospect the globs/paths given on the command line and launch harvesters
-	for _, fileconfig := range config.Files {
+	// Prospect the globs/paths given on the command line and launch
+	// harvesters. Each files entry gets its own channel, which the router
+	// takes from.
+	prospector_chans := RouteFiles(config.Files, routes)
+	for i, fileconfig := range config.Files {
 		prospector := &Prospector{FileConfig: fileconfig}
-		go prospector.Prospect(restart, event_chan)
+		go prospector.Prospect(restart, prospector_chans[i])
 		pendingProspectorCnt++
 	}
 
@@ -195,15 +239,43 @@ func main() {
 
 	emit("All prospectors initialised with %d states to persist\n", len(persist))
 
-	// Harvesters dump events into the spooler.
-	go Spool(event_chan, publisher_chan, options.spoolSize, options.idleTimeout)
+	// Harvesters dump events into the router, which hands each one to the
+	// spooler and publisher of every output its file routes to.
+	for name, spool_chan := range routes {
+		output := config.Outputs[name]
+		publisher_chan := make(chan []*FileEvent, 1)
+		go Spool(spool_chan, publisher_chan, options.spoolSize, options.idleTimeout)
+		go Publish(&output, publisher_chan, registrar_chan)
+	}
 
-	go Publishv1(publisher_chan, registrar_chan, &config.Network)
+	if options.statsInterval > 0 {
+		go ReportStats(options.statsInterval)
+	}
 
 	// registrar records last acknowledged positions in all files.
 	Registrar(persist, registrar_chan)
 }
 
+// Checks the configuration without starting anything, and exits with
+// exitStat.usageError if there are any problems.
+func configTest() {
+	options.quiet = true
+
+	config_files, err := DiscoverConfigs(options.configArg)
+	if err != nil {
+		exit(exitStat.usageError, "Could not use -config of '%s': %s", options.configArg, err)
+	}
+
+	problems := CheckConfigs(config_files)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stderr, problem)
+	}
+	if len(problems) > 0 {
+		exit(exitStat.usageError, "Configuration has %d problem(s)", len(problems))
+	}
+	exit(exitStat.ok, "Configuration OK")
+}
+
 // REVU: yes, this is a temp hack.
 func emit(msgfmt string, args ...interface{}) {
 	if options.quiet {
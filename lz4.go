@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+)
+
+// A minimal compressor for the LZ4 block format, see
+// https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md
+//
+// It trades ratio for speed: a single-entry hash table of 4 byte sequences
+// and greedy matching, with no search for longer matches.
+
+const (
+	lz4MinMatch     = 4
+	lz4HashLog      = 14
+	lz4MaxOffset    = 65535
+	lz4LastLiterals = 5  // the last 5 bytes are always literals
+	lz4MatchLimit   = 12 // the last match must start 12 bytes before the end
+)
+
+func lz4CompressBlock(src []byte) []byte {
+	dst := make([]byte, 0, len(src)+len(src)/255+16)
+	var table [1 << lz4HashLog]int32 // position+1 of the last sequence seen per hash
+
+	anchor := 0
+	for i := 0; i+lz4MatchLimit < len(src); {
+		sequence := binary.LittleEndian.Uint32(src[i:])
+		hash := (sequence * 2654435761) >> (32 - lz4HashLog)
+		ref := int(table[hash]) - 1
+		table[hash] = int32(i + 1)
+
+		if ref < 0 || i-ref > lz4MaxOffset || binary.LittleEndian.Uint32(src[ref:]) != sequence {
+			i++
+			continue
+		}
+
+		end := i + lz4MinMatch
+		for end < len(src)-lz4LastLiterals && src[end] == src[ref+end-i] {
+			end++
+		}
+
+		dst = lz4AppendSequence(dst, src[anchor:i], i-ref, end-i)
+		i = end
+		anchor = i
+	}
+
+	return lz4AppendSequence(dst, src[anchor:], 0, 0)
+}
+
+// Appends literals followed by a match of length at offset. A zero length
+// ends the block with literals only.
+func lz4AppendSequence(dst []byte, literals []byte, offset int, length int) []byte {
+	var token byte
+	if len(literals) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(literals)) << 4
+	}
+	if length > 0 {
+		if length-lz4MinMatch >= 15 {
+			token |= 15
+		} else {
+			token |= byte(length - lz4MinMatch)
+		}
+	}
+
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals)-15)
+	}
+	dst = append(dst, literals...)
+
+	if length > 0 {
+		dst = append(dst, byte(offset), byte(offset>>8))
+		if length-lz4MinMatch >= 15 {
+			dst = lz4AppendLength(dst, length-lz4MinMatch-15)
+		}
+	}
+	return dst
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// The reference decoder for the block format, used to check the compressor.
+func lz4DecompressBlock(src []byte) ([]byte, error) {
+	var dst []byte
+	readLength := func(i *int, n int) (int, error) {
+		for {
+			if *i >= len(src) {
+				return 0, errors.New("truncated length")
+			}
+			b := src[*i]
+			*i++
+			n += int(b)
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		literals := int(token >> 4)
+		if literals == 15 {
+			var err error
+			if literals, err = readLength(&i, literals); err != nil {
+				return nil, err
+			}
+		}
+		if i+literals > len(src) {
+			return nil, errors.New("truncated literals")
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errors.New("truncated offset")
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+		length := int(token & 15)
+		if length == 15 {
+			var err error
+			if length, err = readLength(&i, length); err != nil {
+				return nil, err
+			}
+		}
+		length += lz4MinMatch
+		if offset == 0 || offset > len(dst) {
+			return nil, errors.New("bad offset")
+		}
+		for start := len(dst) - offset; length > 0; length-- {
+			dst = append(dst, dst[start])
+			start++
+		}
+	}
+	return dst, nil
+}
+
+func TestLZ4RoundTrip(t *testing.T) {
+	random := make([]byte, 5000)
+	rand.Read(random)
+
+	inputs := [][]byte{
+		[]byte(""),
+		[]byte("short"),
+		[]byte("exactly thirteen"),
+		[]byte(strings.Repeat("a", 1000)),
+		[]byte(strings.Repeat("2014-07-04 01:20:23 GET /index.html 200\n", 300)),
+		random,
+	}
+
+	for _, input := range inputs {
+		compressed := lz4CompressBlock(input)
+		output, err := lz4DecompressBlock(compressed)
+		if err != nil {
+			t.Fatalf("Failed to decompress %d bytes: %s", len(input), err)
+		}
+		if !bytes.Equal(input, output) {
+			t.Fatalf("Round trip of %d bytes gave %d different bytes", len(input), len(output))
+		}
+	}
+
+	repetitive := []byte(strings.Repeat("2014-07-04 01:20:23 GET /index.html 200\n", 300))
+	if compressed := lz4CompressBlock(repetitive); len(compressed) > len(repetitive)/10 {
+		t.Fatalf("Expected repetitive input to compress well, got %d of %d bytes", len(compressed), len(repetitive))
+	}
+}
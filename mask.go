@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+)
+
+// Replaces sensitive data found by regexps, either with fixed text or with
+// a keyed hash, so that equal values can still be correlated.
+type masker struct {
+	rules       []maskRule
+	replacement string
+	key         []byte
+}
+
+type maskRule struct {
+	name    string
+	pattern *regexp.Regexp
+	spans   func(text string, start, end int) [][2]int /* to mask in a match, nil masks all of it */
+}
+
+const defaultMaskReplacement = "[MASKED]"
+
+// The built-in detectors a mask processor may use, by name.
+var maskDetectors = map[string]maskRule{
+	"pan": {
+		pattern: regexp.MustCompile(`\b\d(?:[ -]?\d)*\b`),
+		spans:   cardNumbers,
+	},
+	"email": {
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`),
+	},
+	"ipv4": {
+		pattern: regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`),
+	},
+	"ipv6": {
+		pattern: regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f]*:[0-9A-Fa-f:.]*`),
+		spans:   ipv6Address,
+	},
+	"jwt": {
+		pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+	},
+}
+
+func newMasker(config *ProcessorConfig) (*masker, error) {
+	if config.Field != "" {
+		return nil, fmt.Errorf("mask processor masks the line and every field, it takes no field")
+	}
+	if config.Replacement != "" && config.HashKey != "" {
+		return nil, fmt.Errorf("give a mask processor either a replacement or a hash key, not both")
+	}
+
+	m := &masker{replacement: config.Replacement, key: []byte(config.HashKey)}
+	if m.replacement == "" {
+		m.replacement = defaultMaskReplacement
+	}
+	for _, name := range config.Detectors {
+		rule, ok := maskDetectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown mask detector '%s', expected 'pan', 'email', 'ipv4', 'ipv6' or 'jwt'", name)
+		}
+		rule.name = name
+		m.rules = append(m.rules, rule)
+	}
+	for _, rule := range config.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("mask rule '%s' has no name", rule.Pattern)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask rule '%s' pattern '%s': %s", rule.Name, rule.Pattern, err)
+		}
+		m.rules = append(m.rules, maskRule{name: rule.Name, pattern: pattern})
+	}
+	if len(m.rules) == 0 {
+		return nil, fmt.Errorf("mask processor has no detectors or rules")
+	}
+	return m, nil
+}
+
+// Masks every match of each rule in turn, counting the hits of each rule in
+// the mask.<rule>.hits statistic.
+func (m *masker) Mask(text string) string {
+	for _, rule := range m.rules {
+		matches := rule.pattern.FindAllStringIndex(text, -1)
+		if matches == nil {
+			continue
+		}
+
+		var masked []byte
+		last, hits := 0, 0
+		for _, match := range matches {
+			spans := [][2]int{{match[0], match[1]}}
+			if rule.spans != nil {
+				spans = rule.spans(text, match[0], match[1])
+			}
+			for _, span := range spans {
+				if span[0] == span[1] {
+					continue
+				}
+				masked = append(masked, text[last:span[0]]...)
+				masked = append(masked, m.replace(text[span[0]:span[1]])...)
+				last = span[1]
+				hits++
+			}
+		}
+		if hits > 0 {
+			text = string(append(masked, text[last:]...))
+			countStat("mask."+rule.name+".hits", uint64(hits))
+		}
+	}
+	return text
+}
+
+func (m *masker) replace(value string) string {
+	if len(m.key) == 0 {
+		return m.replacement
+	}
+	mac := hmac.New(sha256.New, m.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// The card numbers in a run of digits, which may be grouped by spaces or
+// dashes: from each group in turn, the most groups holding 13 to 19 digits
+// that pass the Luhn check. A number is never taken from inside a group, so
+// a longer number such as an order id isn't masked in part.
+func cardNumbers(text string, start, end int) (spans [][2]int) {
+	if end-start < 13 {
+		return nil
+	}
+	var starts, ends []int
+	for i := start; i < end; i++ {
+		if i == start || text[i-1] == ' ' || text[i-1] == '-' {
+			starts = append(starts, i)
+		}
+		if i == end-1 || text[i+1] == ' ' || text[i+1] == '-' {
+			ends = append(ends, i+1)
+		}
+	}
+
+	next := start
+	for _, from := range starts {
+		if from < next {
+			continue
+		}
+		for i := len(ends) - 1; i >= 0; i-- {
+			if to := ends[i]; to > from && isLuhnValid(text, from, to) {
+				spans = append(spans, [2]int{from, to})
+				next = to
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// Whether the digits of a card number candidate pass the Luhn check.
+func isLuhnValid(text string, start, end int) bool {
+	sum, digits := 0, 0
+	for i := end - 1; i >= start; i-- {
+		if text[i] < '0' || text[i] > '9' {
+			continue
+		}
+		digit := int(text[i] - '0')
+		if digits%2 == 1 {
+			if digit *= 2; digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+	}
+	return digits >= 13 && digits <= 19 && sum%10 == 0
+}
+
+// The ipv6 address in a candidate standing on its own, rather than part of
+// a longer word such as "Foo::bar". Dots and colons ending the candidate
+// may be punctuation after the address, as in "from fe80::1.", so they are
+// dropped one at a time until what is left is an address.
+func ipv6Address(text string, start, end int) [][2]int {
+	if start > 0 && isAddressByte(text[start-1]) || end < len(text) && isAddressByte(text[end]) {
+		return nil
+	}
+	for ; end > start; end-- {
+		if net.ParseIP(text[start:end]) != nil {
+			return [][2]int{{start, end}}
+		}
+		if c := text[end-1]; c != '.' && c != ':' {
+			break
+		}
+	}
+	return nil
+}
+
+func isAddressByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == ':' || c == '.' || c == '_'
+}
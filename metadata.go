@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const Version = "0.3.1"
+
+// Metadata a file entry may ask to have sent with each of its events, by
+// the name of the field it is sent as.
+var eventMetadata = map[string]func(event *FileEvent) string{
+	"read_timestamp": func(event *FileEvent) string {
+		return formatTimestamp(event.readTime)
+	},
+	"line_number": func(event *FileEvent) string {
+		return strconv.FormatUint(event.Line, 10)
+	},
+	"inode": func(event *FileEvent) string {
+		inode, _ := file_ids(event.fileinfo)
+		return strconv.FormatUint(inode, 10)
+	},
+	"device": func(event *FileEvent) string {
+		// The type of device numbers differs between platforms
+		_, device := file_ids(event.fileinfo)
+		return fmt.Sprint(device)
+	},
+	"file_size": func(event *FileEvent) string {
+		return strconv.FormatInt(event.fileSize, 10)
+	},
+	"forwarder_version": func(event *FileEvent) string {
+		return Version
+	},
+}
+
+func checkMetadata(names []string) error {
+	for _, name := range names {
+		if _, ok := eventMetadata[name]; !ok {
+			return fmt.Errorf("unknown metadata '%s'", name)
+		}
+	}
+	return nil
+}
+
+// Sets the hostname sent with every event: the configured one if there is
+// one, otherwise the OS's, which the "fqdn" lookup fully qualifies through
+// DNS. If that lookup fails the OS's hostname is used as it is.
+func configureHostname(config *Config) error {
+	if config.Hostname != "" {
+		hostname = config.Hostname
+		return nil
+	}
+
+	name, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("Failed to get the hostname: %s", err)
+	}
+	switch config.HostnameLookup {
+	case "", "os":
+	case "fqdn":
+		if fqdn, err := lookupFQDN(name); err != nil {
+			emit("Failed to look up the fully qualified name of %s, using it as is: %s\n", name, err)
+		} else {
+			name = fqdn
+		}
+	default:
+		return fmt.Errorf("unknown hostname lookup '%s', expected 'os' or 'fqdn'", config.HostnameLookup)
+	}
+	hostname = name
+	return nil
+}
+
+func lookupFQDN(name string) (string, error) {
+	if cname, err := net.LookupCNAME(name); err == nil {
+		if cname = strings.TrimSuffix(cname, "."); strings.Contains(cname, ".") {
+			return cname, nil
+		}
+	}
+
+	addresses, err := net.LookupHost(name)
+	if err != nil {
+		return "", err
+	}
+	for _, address := range addresses {
+		if names, err := net.LookupAddr(address); err == nil && len(names) > 0 {
+			return strings.TrimSuffix(names[0], "."), nil
+		}
+	}
+	return "", fmt.Errorf("no names for %v", addresses)
+}
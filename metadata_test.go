@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEventMetadata(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+	chkerr(t, ioutil.WriteFile(logfile, []byte("first\nsecond\n"), 0644))
+	info, err := os.Stat(logfile)
+	chkerr(t, err)
+	inode, device := file_ids(&info)
+
+	hostname = "myhost"
+	fileconfig := &FileConfig{
+		Metadata: []string{"read_timestamp", "line_number", "inode", "device", "file_size", "forwarder_version"},
+	}
+	event := testEvent(logfile, "second", 6, map[string]string{"type": "app"})
+	event.Line = 2
+	event.fileinfo = &info
+	event.fileconfig = fileconfig
+	event.readTime = time.Date(2015, 3, 1, 12, 30, 15, 250e6, time.FixedZone("CET", 3600))
+	event.timestamp = event.readTime
+	event.fileSize = 13
+
+	pairs := make(map[string]string)
+	for _, pair := range eventPairs(event) {
+		pairs[pair.key] = pair.value
+	}
+	expected := map[string]string{
+		"file":              logfile,
+		"host":              "myhost",
+		"offset":            "6",
+		"line":              "second",
+		"type":              "app",
+		"read_timestamp":    "2015-03-01T11:30:15.250Z",
+		"timestamp":         "2015-03-01T11:30:15.250Z",
+		"line_number":       "2",
+		"inode":             strconv.FormatUint(inode, 10),
+		"device":            fmt.Sprint(device),
+		"file_size":         "13",
+		"forwarder_version": Version,
+	}
+	for key, value := range expected {
+		if pairs[key] != value {
+			t.Errorf("Expected %s to be %q, got %q", key, value, pairs[key])
+		}
+	}
+	if len(pairs) != len(expected) {
+		t.Errorf("Expected %d pairs, got %v", len(expected), pairs)
+	}
+
+	if err := checkMetadata([]string{"line_number", "inode_number"}); err == nil {
+		t.Errorf("Expected unknown metadata to be an error")
+	}
+}
+
+func TestConfigureHostname(t *testing.T) {
+	defer func(saved string) { hostname = saved }(hostname)
+
+	chkerr(t, configureHostname(&Config{Hostname: "web-1.example.com", HostnameLookup: "fqdn"}))
+	if hostname != "web-1.example.com" {
+		t.Fatalf("Expected the configured hostname, got %q", hostname)
+	}
+
+	chkerr(t, configureHostname(&Config{HostnameLookup: "os"}))
+	if name, _ := os.Hostname(); hostname != name {
+		t.Fatalf("Expected the OS hostname %q, got %q", name, hostname)
+	}
+
+	if err := configureHostname(&Config{HostnameLookup: "dns"}); err == nil {
+		t.Fatalf("Expected an unknown hostname lookup to be an error")
+	}
+}
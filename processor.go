@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// A step run on each event of a file before it is spooled. A parser reads
+// the line, or the field it is given, and adds the fields it finds; a
+// rewrite changes the line and the values of every field.
+type processor struct {
+	field      string /* to parse, the line if empty */
+	failureTag string
+	parse      func(text string, fields map[string]string) bool
+	rewrite    func(text string) string /* instead of parse, for the line and every field */
+}
+
+// The processors of a file entry, run in order on each of its events.
+type processorChain []*processor
+
+var defaultFailureTags = map[string]string{
+	"regex": "_regexparsefailure",
+	"kv":    "_kvparsefailure",
+	"csv":   "_csvparsefailure",
+	"tsv":   "_csvparsefailure",
+}
+
+func newProcessorChain(configs []ProcessorConfig) (chain processorChain, err error) {
+	for i := range configs {
+		p, err := newProcessor(&configs[i])
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, p)
+	}
+	return chain, nil
+}
+
+func newProcessor(config *ProcessorConfig) (*processor, error) {
+	p := &processor{field: config.Field, failureTag: config.FailureTag}
+	if p.failureTag == "" {
+		p.failureTag = defaultFailureTags[config.Type]
+	}
+
+	var err error
+	switch config.Type {
+	case "regex":
+		p.parse, err = newRegexParser(config)
+	case "kv":
+		p.parse, err = newKVParser(config)
+	case "csv", "tsv":
+		p.parse, err = newCSVParser(config)
+	case "mask":
+		var m *masker
+		if m, err = newMasker(config); err == nil {
+			p.rewrite = m.Mask
+		}
+	case "":
+		err = fmt.Errorf("processor has no type")
+	default:
+		err = fmt.Errorf("unknown processor type '%s', expected 'regex', 'kv', 'csv', 'tsv' or 'mask'", config.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(p.failureTag, ",") {
+		return nil, fmt.Errorf("invalid failure tag '%s', tags must be without commas", p.failureTag)
+	}
+	return p, nil
+}
+
+// Sets each named group of the pattern that takes part in the match.
+func newRegexParser(config *ProcessorConfig) (func(string, map[string]string) bool, error) {
+	pattern, err := regexp.Compile(config.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex processor pattern '%s': %s", config.Pattern, err)
+	}
+	names := pattern.SubexpNames()
+	named := 0
+	for _, name := range names[1:] {
+		if name != "" {
+			if isReservedField(name) {
+				return nil, fmt.Errorf("regex processor group '%s' is a reserved field", name)
+			}
+			named++
+		}
+	}
+	if named == 0 {
+		return nil, fmt.Errorf("regex processor pattern '%s' has no named groups", config.Pattern)
+	}
+
+	return func(text string, fields map[string]string) bool {
+		match := pattern.FindStringSubmatchIndex(text)
+		if match == nil {
+			return false
+		}
+		for i, name := range names {
+			if name != "" && match[2*i] >= 0 {
+				fields[name] = text[match[2*i]:match[2*i+1]]
+			}
+		}
+		return true
+	}, nil
+}
+
+// Splits the text into pairs on the field separator (default " "), and each
+// pair into key and value on the value separator (default "="). Values may be
+// double quoted to hold the field separator. Pieces without a value
+// separator are skipped; text without any pair fails to parse.
+func newKVParser(config *ProcessorConfig) (func(string, map[string]string) bool, error) {
+	fieldSplit, valueSplit := config.FieldSeparator, config.ValueSeparator
+	if fieldSplit == "" {
+		fieldSplit = " "
+	}
+	if valueSplit == "" {
+		valueSplit = "="
+	}
+	if fieldSplit == valueSplit {
+		return nil, fmt.Errorf("kv processor field and value separators are both '%s'", fieldSplit)
+	}
+
+	return func(text string, fields map[string]string) bool {
+		found := false
+		for _, piece := range splitQuoted(text, fieldSplit) {
+			i := strings.Index(piece, valueSplit)
+			if i <= 0 {
+				continue
+			}
+			key, value := piece[:i], piece[i+len(valueSplit):]
+			if isReservedField(key) {
+				continue
+			}
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			fields[key] = value
+			found = true
+		}
+		return found
+	}, nil
+}
+
+// Splits text on separator, except inside double quotes, leaving out empty
+// pieces.
+func splitQuoted(text string, separator string) (pieces []string) {
+	start, quoted := 0, false
+	for i := 0; i < len(text); i++ {
+		if text[i] == '"' {
+			quoted = !quoted
+		} else if !quoted && strings.HasPrefix(text[i:], separator) {
+			if i > start {
+				pieces = append(pieces, text[start:i])
+			}
+			i += len(separator) - 1
+			start = i + 1
+		}
+	}
+	if start < len(text) {
+		pieces = append(pieces, text[start:])
+	}
+	return pieces
+}
+
+// Maps the values of a csv or tsv record onto the columns in order. A record
+// with more or fewer values than there are columns fails to parse; columns
+// named "" are skipped.
+func newCSVParser(config *ProcessorConfig) (func(string, map[string]string) bool, error) {
+	if len(config.Columns) == 0 {
+		return nil, fmt.Errorf("%s processor has no columns", config.Type)
+	}
+	for _, column := range config.Columns {
+		if isReservedField(column) {
+			return nil, fmt.Errorf("%s processor column '%s' is a reserved field", config.Type, column)
+		}
+	}
+
+	separator := config.Separator
+	if separator == "" {
+		separator = ","
+		if config.Type == "tsv" {
+			separator = "\t"
+		}
+	}
+	comma, size := utf8.DecodeRuneInString(separator)
+	if size != len(separator) || comma == '"' || comma == '\r' || comma == '\n' {
+		return nil, fmt.Errorf("invalid %s processor separator '%s', expected a single character", config.Type, separator)
+	}
+	columns := config.Columns
+
+	return func(text string, fields map[string]string) bool {
+		reader := csv.NewReader(strings.NewReader(text))
+		reader.Comma = comma
+		reader.LazyQuotes = true
+		values, err := reader.Read()
+		if err != nil || len(values) != len(columns) {
+			return false
+		}
+		for i, column := range columns {
+			if column != "" {
+				fields[column] = values[i]
+			}
+		}
+		return true
+	}, nil
+}
+
+// Runs the processors on an event, giving it its own fields. A parser
+// that fails, or whose field is missing, adds its failure tag to the "tags"
+// field and the chain carries on. Returns how many failed.
+func (chain processorChain) Process(event *FileEvent) (failed int) {
+	fields := make(map[string]string, len(*event.Fields)+8)
+	for name, value := range *event.Fields {
+		fields[name] = value
+	}
+
+	for _, p := range chain {
+		if p.rewrite != nil {
+			line := p.rewrite(*event.Text)
+			event.Text = &line
+			for name, value := range fields {
+				fields[name] = p.rewrite(value)
+			}
+			continue
+		}
+
+		text, ok := *event.Text, true
+		if p.field != "" {
+			text, ok = fields[p.field]
+		}
+		if !ok || !p.parse(text, fields) {
+			addTag(fields, p.failureTag)
+			failed++
+		}
+	}
+	event.Fields = &fields
+	return failed
+}
+
+func addTag(fields map[string]string, tag string) {
+	tags := fields["tags"]
+	if tags == "" {
+		fields["tags"] = tag
+		return
+	}
+	for _, existing := range strings.Split(tags, ",") {
+		if existing == tag {
+			return
+		}
+	}
+	fields["tags"] = tags + "," + tag
+}
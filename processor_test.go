@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProcessorChain(t *testing.T) {
+	chain, err := newProcessorChain([]ProcessorConfig{
+		{Type: "regex", Pattern: `^(?P<level>[A-Z]+) (?P<message>.*)$`},
+		{Type: "kv", Field: "message"},
+		{Type: "csv", Field: "path", Columns: []string{"dir", "", "name"}, Separator: "/"},
+	})
+	chkerr(t, err)
+
+	event := testEvent("/var/log/app.log", `INFO user=alice path=var/log/app msg="signed in"`, 0, map[string]string{"type": "app"})
+	if failed := chain.Process(event); failed != 0 {
+		t.Fatalf("Expected every processor to succeed, %d failed: %v", failed, *event.Fields)
+	}
+	expected := map[string]string{
+		"type":    "app",
+		"level":   "INFO",
+		"message": `user=alice path=var/log/app msg="signed in"`,
+		"user":    "alice",
+		"path":    "var/log/app",
+		"msg":     "signed in",
+		"dir":     "var",
+		"name":    "app",
+	}
+	if !reflect.DeepEqual(*event.Fields, expected) {
+		t.Fatalf("Expected fields %v, got %v", expected, *event.Fields)
+	}
+
+	shared := map[string]string{"tags": "web"}
+	event = testEvent("/var/log/app.log", "not a log line", 0, shared)
+	if failed := chain.Process(event); failed != 3 {
+		t.Fatalf("Expected 3 processors to fail, got %d", failed)
+	}
+	if tags := (*event.Fields)["tags"]; tags != "web,_regexparsefailure,_kvparsefailure,_csvparsefailure" {
+		t.Fatalf("Expected failure tags, got %q", tags)
+	}
+	if shared["tags"] != "web" {
+		t.Fatalf("Expected the file's fields to be left alone, got %v", shared)
+	}
+}
+
+func TestCSVProcessor(t *testing.T) {
+	chain, err := newProcessorChain([]ProcessorConfig{
+		{Type: "tsv", Columns: []string{"status", "bytes", "agent"}, FailureTag: "badtsv"},
+	})
+	chkerr(t, err)
+
+	event := testEvent("/var/log/a.log", "200\t512\t\"Mozilla/5.0 (X11)\"", 0, map[string]string{})
+	chain.Process(event)
+	expected := map[string]string{"status": "200", "bytes": "512", "agent": "Mozilla/5.0 (X11)"}
+	if !reflect.DeepEqual(*event.Fields, expected) {
+		t.Fatalf("Expected fields %v, got %v", expected, *event.Fields)
+	}
+
+	event = testEvent("/var/log/a.log", "200\t512", 0, map[string]string{})
+	chain.Process(event)
+	if (*event.Fields)["tags"] != "badtsv" || (*event.Fields)["status"] != "" {
+		t.Fatalf("Expected a short record to fail without fields, got %v", *event.Fields)
+	}
+}
+
+func TestProcessorConfigErrors(t *testing.T) {
+	for _, config := range []ProcessorConfig{
+		{},
+		{Type: "grok"},
+		{Type: "regex", Pattern: `(`},
+		{Type: "regex", Pattern: `^(\w+)`},
+		{Type: "regex", Pattern: `^(?P<line>\w+)`},
+		{Type: "kv", FieldSeparator: "=", ValueSeparator: "="},
+		{Type: "csv"},
+		{Type: "csv", Columns: []string{"a"}, Separator: ";;"},
+		{Type: "csv", Columns: []string{"a"}, FailureTag: "a,b"},
+	} {
+		if _, err := newProcessor(&config); err == nil {
+			t.Errorf("Expected %+v to be an error", config)
+		}
+	}
+}
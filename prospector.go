@@ -22,17 +22,30 @@ type Prospector struct {
 	prospectorinfo map[string]ProspectorInfo
 	iteration      uint32
 	lastscan       time.Time
+	lanes          *harvesterLanes /* of each harvester, into the entry's output */
 }
 
 func (p *Prospector) Prospect(resume *ProspectorResume, output chan *FileEvent) {
 	p.prospectorinfo = make(map[string]ProspectorInfo)
+	p.lanes = newHarvesterLanes()
+	go p.lanes.run(output)
+
+	// Inputs other than files are read from their paths as they are, with no
+	// state to resume from
+	if p.FileConfig.Input != "file" {
+		for _, path := range p.FileConfig.Paths {
+			harvester := &StreamHarvester{Path: path, FileConfig: p.FileConfig, Input: p.FileConfig.Input}
+			go p.harvest(harvester)
+		}
+		resume.persist <- &FileState{Source: nil}
+		return
+	}
 
 	// Handle any "-" (stdin) paths
 	for i, path := range p.FileConfig.Paths {
 		if path == "-" {
-			// Offset and Initial never get used when path is "-"
-			harvester := Harvester{Path: path, FileConfig: p.FileConfig}
-			go harvester.Harvest(output)
+			harvester := &StreamHarvester{Path: path, FileConfig: p.FileConfig, Input: "stdin"}
+			go p.harvest(harvester)
 
 			// Remove it from the file list
 			p.FileConfig.Paths = append(p.FileConfig.Paths[:i], p.FileConfig.Paths[i+1:]...)
@@ -44,7 +57,7 @@ func (p *Prospector) Prospect(resume *ProspectorResume, output chan *FileEvent)
 
 	// Now let's do one quick scan to pick up new files
 	for _, path := range p.FileConfig.Paths {
-		p.scan(path, output, resume)
+		p.scan(path, resume)
 	}
 
 	// This signals we finished considering the previous state
@@ -58,7 +71,7 @@ func (p *Prospector) Prospect(resume *ProspectorResume, output chan *FileEvent)
 
 		for _, path := range p.FileConfig.Paths {
 			// Scan - flag false so new files always start at beginning
-			p.scan(path, output, nil)
+			p.scan(path, nil)
 		}
 
 		p.lastscan = newlastscan
@@ -77,7 +90,16 @@ func (p *Prospector) Prospect(resume *ProspectorResume, output chan *FileEvent)
 	}
 } /* Prospect */
 
-func (p *Prospector) scan(path string, output chan *FileEvent, resume *ProspectorResume) {
+// Runs a harvester, which sends its events on a lane of its own.
+func (p *Prospector) harvest(harvester interface {
+	Harvest(output chan *FileEvent)
+}) {
+	lane := p.lanes.lane()
+	harvester.Harvest(lane)
+	close(lane)
+}
+
+func (p *Prospector) scan(path string, resume *ProspectorResume) {
 
 	// Evaluate the path as a wildcards/shell glob
 	matches, err := filepath.Glob(path)
@@ -103,6 +125,10 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 			emit("Skipping directory: %s\n", file)
 			continue
 		}
+		if !fileinfo.Mode().IsRegular() {
+			emit("Skipping %s: not a regular file, use the pipe or unix inputs for it\n", file)
+			continue
+		}
 
 		// Check the current info against p.prospectorinfo[file]
 		lastinfo, is_known := p.prospectorinfo[file]
@@ -131,8 +157,8 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 				// Once we detect changes again we can resume another harvester again - this keeps number of go routines to a minimum
 				if is_resuming {
 					emit("Resuming harvester on a previously harvested file: %s\n", file)
-					harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Offset: offset, FinishChan: newinfo.harvester}
-					go harvester.Harvest(output)
+					harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Offset: offset, Info: fileinfo, FinishChan: newinfo.harvester}
+					go p.harvest(harvester)
 				} else {
 					// Old file, skip it, but push offset of file size so we start from the end if this file changes and needs picking up
 					emit("Skipping file (older than dead time of %v): %s\n", p.FileConfig.deadtime, file)
@@ -159,9 +185,17 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 					emit("Launching harvester on new file: %s\n", file)
 				}
 
-				// Launch the harvester
-				harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Offset: offset, FinishChan: newinfo.harvester}
-				go harvester.Harvest(output)
+				// Launch the harvester. A file without state starts at the start
+				// position if it was there at startup, otherwise it has been
+				// rotated in since.
+				harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Offset: offset, Info: fileinfo, FinishChan: newinfo.harvester}
+				if !is_resuming {
+					harvester.Start = p.FileConfig.rotatedStartPosition
+					if resume != nil {
+						harvester.Start = p.FileConfig.startPosition
+					}
+				}
+				go p.harvest(harvester)
 			}
 		} else {
 			// Update the fileinfo information used for future comparisons, and the last_seen counter
@@ -183,8 +217,8 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 					newinfo.harvester = make(chan int64, 1)
 
 					// Start a harvester on the path
-					harvester := &Harvester{Path: file, FileConfig: p.FileConfig, FinishChan: newinfo.harvester}
-					go harvester.Harvest(output)
+					harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Info: fileinfo, FinishChan: newinfo.harvester, Start: p.FileConfig.rotatedStartPosition}
+					go p.harvest(harvester)
 				}
 
 				// Keep the old file in missinginfo so we don't rescan it if it was renamed and we've not yet reached the new filename
@@ -196,8 +230,8 @@ func (p *Prospector) scan(path string, output chan *FileEvent, resume *Prospecto
 
 				// Start a harvester on the path; an old file was just modified and it doesn't have a harvester
 				// The offset to continue from will be stored in the harvester channel - so take that to use and also clear the channel
-				harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Offset: <-newinfo.harvester, FinishChan: newinfo.harvester}
-				go harvester.Harvest(output)
+				harvester := &Harvester{Path: file, FileConfig: p.FileConfig, Offset: <-newinfo.harvester, Info: fileinfo, FinishChan: newinfo.harvester}
+				go p.harvest(harvester)
 			}
 		}
 
@@ -211,6 +245,11 @@ func (p *Prospector) calculate_resume(file string, fileinfo os.FileInfo, resume
 	last_state, is_found := resume.files[file]
 
 	if is_found && is_file_same(file, fileinfo, last_state) {
+		if !p.is_content_same(file, last_state) {
+			emit("Not resuming %s: its inode was reused by a file with different content\n", file)
+			return 0, false
+		}
+
 		// We're resuming - throw the last state back downstream so we resave it
 		// And return the offset - also force harvest in case the file is old and we're about to skip it
 		resume.persist <- last_state
@@ -221,8 +260,12 @@ func (p *Prospector) calculate_resume(file string, fileinfo os.FileInfo, resume
 		// File has rotated between shutdown and startup
 		// We return last state downstream, with a modified event source with the new file name
 		// And return the offset - also force harvest in case the file is old and we're about to skip it
-		emit("Detected rename of a previously harvested file: %s -> %s\n", previous, file)
 		last_state := resume.files[previous]
+		if !p.is_content_same(file, last_state) {
+			emit("Not resuming %s: its inode was reused by a file with different content\n", file)
+			return 0, false
+		}
+		emit("Detected rename of a previously harvested file: %s -> %s\n", previous, file)
 		last_state.Source = &file
 		resume.persist <- last_state
 		return last_state.Offset, true
@@ -235,3 +278,10 @@ func (p *Prospector) calculate_resume(file string, fileinfo os.FileInfo, resume
 	// New file so just start from an automatic position
 	return 0, false
 }
+
+// When the files entry asks for fingerprints, whether the file starts with
+// the same bytes as the one recorded in state, rather than being a new file
+// that was given its inode.
+func (p *Prospector) is_content_same(file string, state *FileState) bool {
+	return p.FileConfig.FingerprintBytes <= 0 || fingerprintMatches(file, state)
+}
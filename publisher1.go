@@ -38,104 +38,219 @@ func Publishv1(input chan []*FileEvent,
 	var sequence uint32
 	var err error
 
-	socket = connect(config)
-	defer socket.Close()
-
-	for events := range input {
-		buffer.Truncate(0)
-		compressor, _ := zlib.NewWriterLevel(&buffer, 3)
-
-		for _, event := range events {
-			sequence += 1
-			writeDataFrame(event, sequence, compressor)
-		}
-		compressor.Flush()
-		compressor.Close()
-
-		compressed_payload := buffer.Bytes()
-
-		// Send buffer until we're successful...
-		oops := func(err error) {
-			// TODO(sissel): Track how frequently we timeout and reconnect. If we're
-			// timing out too frequently, there's really no point in timing out since
-			// basically everything is slow or down. We'll want to ratchet up the
-			// timeout value slowly until things improve, then ratchet it down once
-			// things seem healthy.
-			emit("Socket error, will reconnect: %s\n", err)
-			time.Sleep(1 * time.Second)
-			socket.Close()
-			socket = connect(config)
-		}
+	retry := newBackoff(config)
+	timeout := newAdaptiveTimeout(config)
 
-	SendPayload:
-		for {
-			// Abort if our whole request takes longer than the configured
-			// network timeout.
-			socket.SetDeadline(time.Now().Add(config.timeout))
+	socket = connect(config, retry)
+	defer socket.Close()
 
-			// Set the window size to the length of this payload in events.
-			_, err = socket.Write([]byte("1W"))
-			if err != nil {
-				oops(err)
+	for spooled := range input {
+		// Split the spool into payloads the server can take in one go; each
+		// is acknowledged on its own. Payloads are measured before they are
+		// compressed, which is what the server has to hold once it inflates
+		// them.
+		for _, events := range splitBatch(spooled, config.MaxPayloadBytes) {
+			if size := dataFrameSize(events[0]); len(events) == 1 && config.MaxPayloadBytes > 0 && size > config.MaxPayloadBytes {
+				// This will never fit, so don't retry it forever
+				emit("Dropping event from %s at offset %d: %d bytes before compression exceeds max payload bytes of %d\n",
+					*events[0].Source, events[0].Offset, size, config.MaxPayloadBytes)
+				countStat("output."+config.name+".events.oversized", 1)
+				registrar <- events
 				continue
 			}
-			binary.Write(socket, binary.BigEndian, uint32(len(events)))
-			if err != nil {
-				oops(err)
+			lines := countLines(events)
+			if lines == 0 {
+				// Only markers, which have nothing to send
+				registrar <- events
 				continue
 			}
 
-			// Write compressed frame
-			socket.Write([]byte("1C"))
+			buffer.Truncate(0)
+			err = writePayload(events, &sequence, config, &buffer)
 			if err != nil {
-				oops(err)
-				continue
+				fault("Failed to encode payload: %s\n", err)
 			}
-			binary.Write(socket, binary.BigEndian, uint32(len(compressed_payload)))
-			if err != nil {
-				oops(err)
-				continue
+			payload := buffer.Bytes()
+
+			// Send buffer until we're successful...
+			reconnect := func(err error) {
+				// Give the server longer next time if it ran out of time, and
+				// back off so a struggling server isn't flooded with reconnects.
+				if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+					timeout.Failure()
+				}
+				retry.Wait()
+				socket.Close()
+				socket = connect(config, retry)
 			}
-			_, err = socket.Write(compressed_payload)
-			if err != nil {
-				oops(err)
-				continue
+			oops := func(err error) {
+				emit("Socket error, will reconnect: %s\n", err)
+				reconnect(err)
 			}
 
-			// read ack
-			response := make([]byte, 0, 6)
-			ackbytes := 0
-			for ackbytes != 6 {
-				n, err := socket.Read(response[len(response):cap(response)])
+		SendPayload:
+			for {
+				// Abort if our whole request takes longer than the current
+				// network timeout.
+				started := time.Now()
+				socket.SetDeadline(started.Add(timeout.Timeout()))
+
+				// Set the window size to the length of this payload in events.
+				_, err = socket.Write([]byte("1W"))
+				if err != nil {
+					oops(err)
+					continue
+				}
+				binary.Write(socket, binary.BigEndian, uint32(lines))
+				if err != nil {
+					oops(err)
+					continue
+				}
+
+				// Write the data frames, compressed or not
+				_, err = socket.Write(payload)
 				if err != nil {
-					emit("Read error looking for ack: %s\n", err)
-					socket.Close()
-					socket = connect(config)
-					continue SendPayload // retry sending on new connection
-				} else {
-					ackbytes += n
+					oops(err)
+					continue
 				}
+
+				// read ack
+				response := make([]byte, 0, 6)
+				ackbytes := 0
+				for ackbytes != 6 {
+					n, err := socket.Read(response[len(response):cap(response)])
+					if err != nil {
+						emit("Read error looking for ack: %s\n", err)
+						reconnect(err)
+						continue SendPayload // retry sending on new connection
+					} else {
+						ackbytes += n
+					}
+				}
+
+				// TODO(sissel): verify ack
+				// Success, stop trying to send the payload.
+				timeout.Success(time.Since(started))
+				retry.Reset()
+				break
 			}
 
-			// TODO(sissel): verify ack
-			// Success, stop trying to send the payload.
-			break
+			// Tell the registrar that we've successfully sent these events
+			registrar <- events
 		}
-
-		// Tell the registrar that we've successfully sent these events
-		registrar <- events
 	} /* for each event payload */
 } // Publish
 
-func connect(config *NetworkConfig) (socket *tls.Conn) {
-	var tlsconfig tls.Config
+// Splits events into batches whose data frames add up to at most max bytes
+// before compression.
+// An event too large to ever fit ends up in a batch of its own.
+func splitBatch(events []*FileEvent, max int64) (batches [][]*FileEvent) {
+	if max <= 0 {
+		return [][]*FileEvent{events}
+	}
+
+	start, size := 0, int64(0)
+	for i, event := range events {
+		frame := dataFrameSize(event)
+		if i > start && size+frame > max {
+			batches = append(batches, events[start:i])
+			start, size = i, 0
+		}
+		size += frame
+	}
+	return append(batches, events[start:])
+}
+
+// The length of the data frame writeDataFrame would write for event, which
+// is none for a marker.
+func dataFrameSize(event *FileEvent) (size int64) {
+	if event.marker {
+		return 0
+	}
+	size = 2 + 4 + 4 // header, sequence, pair count
+	for _, pair := range eventPairs(event) {
+		size += 4 + int64(len(pair.key)) + 4 + int64(len(pair.value))
+	}
+	return size
+}
+
+// Encodes events other than markers as data frames, wrapped in a compressed frame unless
+// compression is "none", and counts the bytes before and after compression.
+func writePayload(events []*FileEvent, sequence *uint32, config *NetworkConfig, output *bytes.Buffer) error {
+	var frames bytes.Buffer
+	for _, event := range events {
+		if event.marker {
+			continue
+		}
+		*sequence += 1
+		writeDataFrame(event, *sequence, &frames)
+	}
+
+	switch config.Compression {
+	case "none":
+		output.Write(frames.Bytes())
+	case "lz4":
+		compressed := lz4CompressBlock(frames.Bytes())
+		output.Write([]byte("1L"))
+		binary.Write(output, binary.BigEndian, uint32(frames.Len()))
+		binary.Write(output, binary.BigEndian, uint32(len(compressed)))
+		output.Write(compressed)
+	default:
+		var compressed bytes.Buffer
+		compressor, err := zlib.NewWriterLevel(&compressed, config.CompressionLevel)
+		if err != nil {
+			return err
+		}
+		compressor.Write(frames.Bytes())
+		compressor.Close()
+		output.Write([]byte("1C"))
+		binary.Write(output, binary.BigEndian, uint32(compressed.Len()))
+		output.Write(compressed.Bytes())
+	}
+
+	countStat("output."+config.name+".bytes.raw", uint64(frames.Len()))
+	countStat("output."+config.name+".bytes.compressed", uint64(output.Len()))
+	return nil
+}
+
+// Connects to one of the servers, waiting on retry between attempts.
+func connect(config *NetworkConfig, retry *backoff) (socket *tls.Conn) {
+	tlsconfig, err := loadTLSConfig(config)
+	if err != nil {
+		fault("%s\n", err)
+	}
+
+	for {
+		host, address, tcpsocket := dialServer(config, retry)
+
+		tlsconfig.ServerName = host
+
+		socket = tls.Client(tcpsocket, tlsconfig)
+		socket.SetDeadline(time.Now().Add(config.timeout))
+		err = socket.Handshake()
+		if err != nil {
+			emit("Failed to tls handshake with %s %s\n", address, err)
+			retry.Wait()
+			socket.Close()
+			continue
+		}
+
+		emit("Connected to %s\n", address)
+
+		// connected, let's rock and roll.
+		return
+	}
+}
+
+func loadTLSConfig(config *NetworkConfig) (*tls.Config, error) {
+	tlsconfig := &tls.Config{}
 
 	if len(config.SSLCertificate) > 0 && len(config.SSLKey) > 0 {
 		emit("Loading client ssl certificate: %s and %s\n",
 			config.SSLCertificate, config.SSLKey)
 		cert, err := tls.LoadX509KeyPair(config.SSLCertificate, config.SSLKey)
 		if err != nil {
-			fault ("Failed loading client ssl certificate: %s\n", err)
+			return nil, fmt.Errorf("Failed loading client ssl certificate: %s", err)
 		}
 		tlsconfig.Certificates = []tls.Certificate{cert}
 	}
@@ -146,24 +261,30 @@ func connect(config *NetworkConfig) (socket *tls.Conn) {
 
 		pemdata, err := ioutil.ReadFile(config.SSLCA)
 		if err != nil {
-			fault("Failure reading CA certificate: %s\n", err)
+			return nil, fmt.Errorf("Failure reading CA certificate: %s", err)
 		}
 
 		block, _ := pem.Decode(pemdata)
 		if block == nil {
-			fault("Failed to decode PEM data, is %s a valid cert?\n", config.SSLCA)
+			return nil, fmt.Errorf("Failed to decode PEM data, is %s a valid cert?", config.SSLCA)
 		}
 		if block.Type != "CERTIFICATE" {
-			fault("This is not a certificate file: %s\n", config.SSLCA)
+			return nil, fmt.Errorf("This is not a certificate file: %s", config.SSLCA)
 		}
 
 		cert, err := x509.ParseCertificate(block.Bytes)
 		if err != nil {
-			fault("Failed to parse a certificate: %s\n", config.SSLCA)
+			return nil, fmt.Errorf("Failed to parse a certificate: %s", config.SSLCA)
 		}
 		tlsconfig.RootCAs.AddCert(cert)
 	}
+	return tlsconfig, nil
+}
 
+// Opens a tcp connection to a random server from the list, backing off and
+// retrying until one succeeds. Returns the host name as configured, and the
+// address dialed.
+func dialServer(config *NetworkConfig, retry *backoff) (host string, addressport string, socket net.Conn) {
 	for {
 		// Pick a random server from the list.
 		hostport := config.Servers[rand.Int()%len(config.Servers)]
@@ -171,18 +292,17 @@ func connect(config *NetworkConfig) (socket *tls.Conn) {
 		if submatch == nil {
 			fault("Invalid host:port given: %s", hostport)
 		}
-		host := string(submatch[1])
+		host = string(submatch[1])
 		port := string(submatch[2])
 		addresses, err := net.LookupHost(host)
 
 		if err != nil {
 			emit("DNS lookup failure \"%s\": %s\n", host, err)
-			time.Sleep(1 * time.Second)
+			retry.Wait()
 			continue
 		}
 
 		address := addresses[rand.Int()%len(addresses)]
-		var addressport string
 
 		ip := net.ParseIP(address)
 		if len(ip) == net.IPv4len {
@@ -193,48 +313,61 @@ func connect(config *NetworkConfig) (socket *tls.Conn) {
 
 		emit("Connecting to %s (%s) \n", addressport, host)
 
-		tcpsocket, err := net.DialTimeout("tcp", addressport, config.timeout)
+		socket, err = net.DialTimeout("tcp", addressport, config.timeout)
 		if err != nil {
 			emit("Failure connecting to %s: %s\n", address, err)
-			time.Sleep(1 * time.Second)
+			retry.Wait()
 			continue
 		}
+		return
+	}
+}
 
-		tlsconfig.ServerName = host
-
-		socket = tls.Client(tcpsocket, &tlsconfig)
-		socket.SetDeadline(time.Now().Add(config.timeout))
-		err = socket.Handshake()
-		if err != nil {
-			emit("Failed to tls handshake with %s %s\n", address, err)
-			time.Sleep(1 * time.Second)
-			socket.Close()
-			continue
-		}
+type eventPair struct {
+	key, value string
+}
 
-		emit("Connected to %s\n", address)
+// The key/value pairs shipped for an event, in wire order.
+func eventPairs(event *FileEvent) []eventPair {
+	var metadata []string
+	if event.fileconfig != nil {
+		metadata = event.fileconfig.Metadata
+	}
 
-		// connected, let's rock and roll.
-		return
+	pairs := make([]eventPair, 0, len(*event.Fields)+len(metadata)+5)
+	pairs = append(pairs,
+		eventPair{"file", *event.Source},
+		eventPair{"host", hostname},
+		eventPair{"offset", strconv.FormatInt(event.Offset, 10)},
+		eventPair{"line", *event.Text})
+	for k, v := range *event.Fields {
+		pairs = append(pairs, eventPair{k, v})
+	}
+	for _, name := range metadata {
+		pairs = append(pairs, eventPair{name, eventMetadata[name](event)})
 	}
-	return
+	// Every event has a time: the one parsed from its line, or else the
+	// time it was read
+	field := defaultConfig.timestampField
+	if event.fileconfig != nil && event.fileconfig.TimestampField != "" {
+		field = event.fileconfig.TimestampField
+	}
+	pairs = append(pairs, eventPair{field, formatTimestamp(event.timestamp)})
+	return pairs
 }
 
 func writeDataFrame(event *FileEvent, sequence uint32, output io.Writer) {
 	//emit("event: %s\n", *event.Text)
+	pairs := eventPairs(event)
 	// header, "1D"
 	output.Write([]byte("1D"))
 	// sequence number
 	binary.Write(output, binary.BigEndian, uint32(sequence))
 	// 'pair' count
-	binary.Write(output, binary.BigEndian, uint32(len(*event.Fields)+4))
+	binary.Write(output, binary.BigEndian, uint32(len(pairs)))
 
-	writeKV("file", *event.Source, output)
-	writeKV("host", hostname, output)
-	writeKV("offset", strconv.FormatInt(event.Offset, 10), output)
-	writeKV("line", *event.Text, output)
-	for k, v := range *event.Fields {
-		writeKV(k, v, output)
+	for _, pair := range pairs {
+		writeKV(pair.key, pair.value, output)
 	}
 }
 
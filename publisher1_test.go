@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"compress/zlib"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/binary"
 	"encoding/pem"
 	"errors"
+	"io"
 	"io/ioutil"
 	"log"
 	"math/big"
 	"net"
 	"os"
+	"path"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -153,6 +161,8 @@ func tryConnect(addr string, strict bool) (errchan chan error) {
 		Servers:   []string{addr},
 		Timeout:   wait,
 		timeout:   time.Second * wait,
+		reconnectBackoff:    time.Second,
+		maxReconnectBackoff: time.Second,
 	}
 
 		var socket *tls.Conn
@@ -183,7 +193,7 @@ func tryConnect(addr string, strict bool) (errchan chan error) {
 func doConnect(config *NetworkConfig) <-chan *tls.Conn {
 	sockchan := make(chan *tls.Conn)
 	go func() {
-		sockchan <- connect(config)
+		sockchan <- connect(config, newBackoff(config))
 	}()
 	return sockchan
 }
@@ -229,3 +239,174 @@ func TestStrictConnectToIpWithSAN(t *testing.T) {
 		t.Fatal("Should not have failed", err)
 	}
 }
+
+// ----------------------------------------------------------------------
+// Payload encoding
+// ----------------------------------------------------------------------
+
+func TestWritePayload(t *testing.T) {
+	events := []*FileEvent{
+		testEvent("/var/log/a.log", "first", 0, map[string]string{"type": "a"}),
+		testEvent("/var/log/a.log", "second", 6, map[string]string{"type": "a"}),
+	}
+
+	var frames bytes.Buffer
+	writeDataFrame(events[0], 1, &frames)
+	writeDataFrame(events[1], 2, &frames)
+
+	for _, compression := range []string{"none", "zlib", "lz4"} {
+		var sequence uint32
+		var payload bytes.Buffer
+		config := &NetworkConfig{Compression: compression, CompressionLevel: 9, name: "test"}
+		chkerr(t, writePayload(events, &sequence, config, &payload))
+
+		if sequence != 2 {
+			t.Fatalf("Expected the sequence to advance to 2, got %d", sequence)
+		}
+
+		data := payload.Bytes()
+		var decoded []byte
+		switch compression {
+		case "none":
+			decoded = data
+		case "zlib":
+			if string(data[:2]) != "1C" || int(binary.BigEndian.Uint32(data[2:])) != len(data)-6 {
+				t.Fatalf("Expected a compressed frame, got %q", data)
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(data[6:]))
+			chkerr(t, err)
+			decoded, err = ioutil.ReadAll(reader)
+			chkerr(t, err)
+		case "lz4":
+			if string(data[:2]) != "1L" || int(binary.BigEndian.Uint32(data[2:])) != frames.Len() || int(binary.BigEndian.Uint32(data[6:])) != len(data)-10 {
+				t.Fatalf("Expected an lz4 frame, got %q", data)
+			}
+			var err error
+			decoded, err = lz4DecompressBlock(data[10:])
+			chkerr(t, err)
+		}
+
+		if !bytes.Equal(decoded, frames.Bytes()) {
+			t.Fatalf("%s: expected the payload to decode to the data frames", compression)
+		}
+	}
+
+	var sequence uint32
+	var payload bytes.Buffer
+	if err := writePayload(events, &sequence, &NetworkConfig{Compression: "zlib", CompressionLevel: 12}, &payload); err == nil {
+		t.Fatalf("Expected an invalid zlib level to give an error")
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	hostname = "myhost"
+	small := testEvent("/var/log/a.log", "small", 0, map[string]string{})
+	large := testEvent("/var/log/a.log", string(make([]byte, 1000)), 6, map[string]string{})
+	frame := dataFrameSize(small)
+
+	var frames bytes.Buffer
+	writeDataFrame(small, 1, &frames)
+	if int64(frames.Len()) != frame {
+		t.Fatalf("Expected a frame size of %d, got %d", frames.Len(), frame)
+	}
+
+	events := []*FileEvent{small, small, small, large, small}
+
+	if batches := splitBatch(events, 0); len(batches) != 1 || len(batches[0]) != 5 {
+		t.Fatalf("Expected no limit to give one batch, got %v", batches)
+	}
+
+	batches := splitBatch(events, 2*frame)
+	expected := [][]*FileEvent{{small, small}, {small}, {large}, {small}}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Fatalf("Expected batches %v, got %v", expected, batches)
+	}
+}
+
+// A lumberjack server on a local port, trusted through a CA of its own,
+// which acknowledges every payload. The window of each is sent on windows.
+func listenLumberjack(t *testing.T, tmpdir string) (*NetworkConfig, chan uint32) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	chkerr(t, err)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, ca, ca, &key.PublicKey, key)
+	chkerr(t, err)
+	cafile := path.Join(tmpdir, "ca.crt")
+	chkerr(t, ioutil.WriteFile(cafile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	chkerr(t, err)
+
+	windows := make(chan uint32, 16)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var header [2]byte
+			var window, sequence, pairs, length uint32
+			if _, err := io.ReadFull(conn, header[:]); err != nil || string(header[:]) != "1W" {
+				return
+			}
+			binary.Read(conn, binary.BigEndian, &window)
+			for i := uint32(0); i < window; i++ {
+				io.ReadFull(conn, header[:])
+				binary.Read(conn, binary.BigEndian, &sequence)
+				binary.Read(conn, binary.BigEndian, &pairs)
+				for j := uint32(0); j < 2*pairs; j++ {
+					binary.Read(conn, binary.BigEndian, &length)
+					io.CopyN(ioutil.Discard, conn, int64(length))
+				}
+			}
+			windows <- window
+			conn.Write([]byte("1A"))
+			binary.Write(conn, binary.BigEndian, sequence)
+		}
+	}()
+
+	config := &NetworkConfig{Servers: []string{listener.Addr().String()}, SSLCA: cafile, Compression: "none"}
+	finalizeNetworkConfig(config)
+	return config, windows
+}
+
+func TestPublishSplitsSpool(t *testing.T) {
+	hostname = "myhost"
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	event := func() *FileEvent {
+		return testEvent("/var/log/a.log", "some line of text", 0, map[string]string{})
+	}
+	config, windows := listenLumberjack(t, tmpdir)
+	config.MaxPayloadBytes = 2 * dataFrameSize(event())
+
+	input := make(chan []*FileEvent, 1)
+	registrar := make(chan []*FileEvent, 16)
+	go Publishv1(input, registrar, config)
+	defer close(input)
+
+	input <- []*FileEvent{event(), event(), event(), event(), event()}
+	for _, expected := range []int{2, 2, 1} {
+		select {
+		case acknowledged := <-registrar:
+			if len(acknowledged) != expected || <-windows != uint32(expected) {
+				t.Fatalf("Expected a payload of %d events to be acknowledged, got %d", expected, len(acknowledged))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Expected a payload of %d events to be acknowledged", expected)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PublishFile writes events as JSON lines to a local file, rotating it once it
+// grows past config.MaxSize and keeping at most config.MaxFiles old copies.
+// Events are acknowledged to the registrar once written and synced to disk.
+func PublishFile(input chan []*FileEvent,
+	registrar chan []*FileEvent,
+	config *OutputConfig) {
+	output := &rotatingFile{path: config.Path, maxSize: config.MaxSize, maxFiles: config.MaxFiles}
+	defer output.Close()
+
+	for events := range input {
+		for {
+			err := output.writeEvents(events)
+			if err == nil {
+				break
+			}
+			emit("Failed writing events to %s, will retry: %s\n", config.Path, err)
+			output.Close()
+			time.Sleep(1 * time.Second)
+		}
+
+		registrar <- events
+	}
+}
+
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func (f *rotatingFile) writeEvents(events []*FileEvent) error {
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+
+	writer := bufio.NewWriter(f)
+	if err := writeJSONLines(events, writer); err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		return err
+	}
+
+	if f.size >= f.maxSize {
+		if err := f.rotate(); err != nil {
+			// The events are safely written, so don't fail them.
+			emit("Failed rotating output file %s: %s\n", f.path, err)
+		}
+	}
+	return nil
+}
+
+func (f *rotatingFile) Write(data []byte) (int, error) {
+	n, err := f.file.Write(data)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) open() (err error) {
+	f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		f.file = nil
+		return err
+	}
+	info, err := f.file.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	f.size = info.Size()
+	return nil
+}
+
+// Shifts path.N to path.N+1, dropping the oldest, and moves the current file
+// to path.1. The next write reopens a fresh file.
+func (f *rotatingFile) rotate() error {
+	f.Close()
+
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		older := fmt.Sprintf("%s.%d", f.path, i)
+		if _, err := os.Stat(older); err == nil {
+			if err := os.Rename(older, fmt.Sprintf("%s.%d", f.path, i+1)); err != nil {
+				return err
+			}
+		}
+	}
+	emit("Rotating output file %s\n", f.path)
+	return os.Rename(f.path, f.path+".1")
+}
+
+func (f *rotatingFile) Close() {
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	hostname = "myhost"
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	output := &rotatingFile{path: path.Join(tmpdir, "events.json"), maxSize: 160, maxFiles: 2}
+	defer output.Close()
+
+	events := []*FileEvent{testEvent("/var/log/a.log", "some line of text", 0, map[string]string{})}
+	for i := 0; i < 8; i++ {
+		chkerr(t, output.writeEvents(events))
+	}
+
+	// Each event is 121 bytes, so every second write rotates.
+	for _, name := range []string{"events.json.1", "events.json.2"} {
+		data, err := ioutil.ReadFile(path.Join(tmpdir, name))
+		chkerr(t, err)
+		if len(data) != 2*121 {
+			t.Fatalf("Expected %s to hold two events, got %q", name, data)
+		}
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "events.json.3")); !os.IsNotExist(err) {
+		t.Fatalf("Expected only 2 rotated files to be kept")
+	}
+	if _, err := os.Stat(path.Join(tmpdir, "events.json")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the current file to be reopened on the next write only")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PublishStdout prints events to standard output, either as JSON lines or as
+// the lumberjack frames the network publisher would send, decoded into text.
+// Events are acknowledged to the registrar once written.
+func PublishStdout(input chan []*FileEvent,
+	registrar chan []*FileEvent,
+	config *OutputConfig) {
+	var sequence uint32
+	var frames bytes.Buffer
+	writer := bufio.NewWriter(os.Stdout)
+
+	for events := range input {
+		var err error
+		switch config.Codec {
+		case "frames":
+			frames.Truncate(0)
+			frames.Write([]byte("1W"))
+			binary.Write(&frames, binary.BigEndian, uint32(countLines(events)))
+			for _, event := range events {
+				if event.marker {
+					continue
+				}
+				sequence += 1
+				writeDataFrame(event, sequence, &frames)
+			}
+			err = dumpFrames(&frames, writer)
+		default:
+			err = writeJSONLines(events, writer)
+		}
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			fault("Failed writing events to stdout: %s\n", err)
+		}
+
+		registrar <- events
+	}
+}
+
+// Writes one JSON object per event, holding the same pairs as a data frame.
+// Markers are left out.
+func writeJSONLines(events []*FileEvent, output io.Writer) error {
+	encoder := json.NewEncoder(output)
+	for _, event := range events {
+		if event.marker {
+			continue
+		}
+		object := make(map[string]string)
+		for _, pair := range eventPairs(event) {
+			object[pair.key] = pair.value
+		}
+		if err := encoder.Encode(object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decodes a stream of uncompressed lumberjack frames and prints them, one
+// frame header per line followed by its key/value pairs.
+func dumpFrames(input io.Reader, output io.Writer) error {
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(input, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch header[1] {
+		case 'W':
+			var window uint32
+			if err := binary.Read(input, binary.BigEndian, &window); err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "%s window=%d\n", header, window)
+		case 'D':
+			var sequence, count uint32
+			if err := binary.Read(input, binary.BigEndian, &sequence); err != nil {
+				return err
+			}
+			if err := binary.Read(input, binary.BigEndian, &count); err != nil {
+				return err
+			}
+			fmt.Fprintf(output, "%s sequence=%d pairs=%d\n", header, sequence, count)
+			for i := uint32(0); i < count; i++ {
+				key, err := readKV(input)
+				if err != nil {
+					return err
+				}
+				value, err := readKV(input)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(output, "  %q: %q\n", key, value)
+			}
+		default:
+			return fmt.Errorf("unknown frame type %q", header)
+		}
+	}
+}
+
+func readKV(input io.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(input, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(input, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
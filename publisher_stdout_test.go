@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func testEvent(source, text string, offset int64, fields map[string]string) *FileEvent {
+	read := time.Date(2015, 3, 1, 11, 30, 15, 0, time.UTC)
+	return &FileEvent{Source: &source, Text: &text, Offset: offset, Fields: &fields, readTime: read, timestamp: read}
+}
+
+func TestWriteJSONLines(t *testing.T) {
+	hostname = "myhost"
+	events := []*FileEvent{
+		testEvent("/var/log/a.log", "first", 0, map[string]string{"type": "a"}),
+		testEvent("/var/log/a.log", "second", 6, map[string]string{"type": "a"}),
+	}
+
+	var output bytes.Buffer
+	chkerr(t, writeJSONLines(events, &output))
+
+	expected := `{"file":"/var/log/a.log","host":"myhost","line":"first","offset":"0","timestamp":"2015-03-01T11:30:15.000Z","type":"a"}
+{"file":"/var/log/a.log","host":"myhost","line":"second","offset":"6","timestamp":"2015-03-01T11:30:15.000Z","type":"a"}
+`
+	if output.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, output.String())
+	}
+}
+
+func TestDumpFrames(t *testing.T) {
+	hostname = "myhost"
+	var frames, output bytes.Buffer
+	frames.Write([]byte{'1', 'W', 0, 0, 0, 1})
+	writeDataFrame(testEvent("/var/log/a.log", "hello", 12, map[string]string{}), 7, &frames)
+
+	chkerr(t, dumpFrames(&frames, &output))
+
+	expected := `1W window=1
+1D sequence=7 pairs=5
+  "file": "/var/log/a.log"
+  "host": "myhost"
+  "offset": "12"
+  "line": "hello"
+  "timestamp": "2015-03-01T11:30:15.000Z"
+`
+	if output.String() != expected {
+		t.Fatalf("Expected\n%s\ngot\n%s", expected, output.String())
+	}
+
+	if err := dumpFrames(bytes.NewBufferString("1X"), &output); err == nil {
+		t.Fatalf("Expected an unknown frame type to give an error")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3,
+	"auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3,
+	"warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// The RFC 5424 header fields that are fixed for every event of a FileConfig,
+// precomputed when the config is loaded.
+type syslogHeader struct {
+	pri            int
+	appName        string
+	structuredData string
+}
+
+func newSyslogHeader(fileconfig *FileConfig) (header syslogHeader, err error) {
+	facility := fileconfig.SyslogFacility
+	if facility == "" {
+		facility = defaultConfig.syslogFacility
+	}
+	severity := fileconfig.SyslogSeverity
+	if severity == "" {
+		severity = defaultConfig.syslogSeverity
+	}
+
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return header, settingErrorf("syslog facility", "unknown syslog facility '%s'", facility)
+	}
+	s, ok := syslogSeverities[severity]
+	if !ok {
+		return header, settingErrorf("syslog severity", "unknown syslog severity '%s'", severity)
+	}
+	header.pri = f*8 + s
+
+	header.appName = fileconfig.SyslogAppName
+	if header.appName == "" {
+		header.appName = defaultConfig.syslogAppName
+	}
+	if !isSyslogName(header.appName, 48) {
+		return header, settingErrorf("syslog app name", "invalid syslog app name '%s'", header.appName)
+	}
+
+	if header.structuredData, err = formatStructuredData(fileconfig.SyslogStructuredData); err != nil {
+		return header, settingErrorf("syslog structured data", "%s", err)
+	}
+	return header, nil
+}
+
+// Renders the SD-ELEMENTs of a message, sorted by SD-ID and param name so
+// the output is stable. Returns the NILVALUE if there are none.
+func formatStructuredData(elements map[string]map[string]string) (string, error) {
+	if len(elements) == 0 {
+		return "-", nil
+	}
+
+	ids := make([]string, 0, len(elements))
+	for id := range elements {
+		if !isSyslogName(id, 32) {
+			return "", fmt.Errorf("invalid syslog SD-ID '%s'", id)
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var sd bytes.Buffer
+	for _, id := range ids {
+		params := elements[id]
+		names := make([]string, 0, len(params))
+		for name := range params {
+			if !isSyslogName(name, 32) {
+				return "", fmt.Errorf("invalid syslog PARAM-NAME '%s' in SD-ID '%s'", name, id)
+			}
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		sd.WriteString("[" + id)
+		for _, name := range names {
+			sd.WriteString(" " + name + "=\"" + escapeParamValue(params[name]) + "\"")
+		}
+		sd.WriteString("]")
+	}
+	return sd.String(), nil
+}
+
+// RFC 5424 SD-NAME and APP-NAME: 1 to max printable US-ASCII characters,
+// excluding '=', ' ', ']' and '"'.
+func isSyslogName(name string, max int) bool {
+	if len(name) == 0 || len(name) > max {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c < 33 || c > 126 || c == '=' || c == ']' || c == '"' {
+			return false
+		}
+	}
+	return true
+}
+
+func escapeParamValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, `]`, `\]`, -1)
+}
+
+// Renders event as an RFC 5424 message with octet-counting framing (RFC 6587).
+func formatSyslogFrame(event *FileEvent, timestamp time.Time, output *bytes.Buffer) {
+	header := &event.fileconfig.syslog
+	msg := fmt.Sprintf("<%d>1 %s %s %s - - %s %s",
+		header.pri, timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHostname(), header.appName, header.structuredData, *event.Text)
+	output.WriteString(strconv.Itoa(len(msg)))
+	output.WriteByte(' ')
+	output.WriteString(msg)
+}
+
+func syslogHostname() string {
+	if hostname == "" {
+		return "-"
+	}
+	return hostname
+}
+
+// PublishSyslog ships events to a syslog collector. Syslog has no
+// application-level acknowledgement, so a batch is considered delivered once
+// it has been completely written to the socket.
+func PublishSyslog(input chan []*FileEvent,
+	registrar chan []*FileEvent,
+	config *NetworkConfig) {
+	var buffer bytes.Buffer
+	retry := newBackoff(config)
+
+	socket := connectSyslog(config)
+	defer socket.Close()
+
+	for events := range input {
+		buffer.Truncate(0)
+		now := time.Now()
+		for _, event := range events {
+			if event.marker {
+				continue
+			}
+			timestamp := event.timestamp
+			if timestamp.IsZero() {
+				timestamp = now
+			}
+			formatSyslogFrame(event, timestamp, &buffer)
+		}
+
+		for {
+			socket.SetDeadline(time.Now().Add(config.timeout))
+			_, err := socket.Write(buffer.Bytes())
+			if err == nil {
+				retry.Reset()
+				break
+			}
+			emit("Syslog socket error, will reconnect: %s\n", err)
+			retry.Wait()
+			socket.Close()
+			socket = connectSyslog(config)
+		}
+
+		registrar <- events
+	}
+}
+
+func connectSyslog(config *NetworkConfig) net.Conn {
+	var tlsconfig *tls.Config
+	if config.Transport == "tls" {
+		var err error
+		if tlsconfig, err = loadTLSConfig(config); err != nil {
+			fault("%s\n", err)
+		}
+	}
+	retry := newBackoff(config)
+
+	for {
+		host, address, tcpsocket := dialServer(config, retry)
+		if config.Transport != "tls" {
+			emit("Connected to syslog server %s\n", address)
+			return tcpsocket
+		}
+
+		tlsconfig.ServerName = host
+		socket := tls.Client(tcpsocket, tlsconfig)
+		socket.SetDeadline(time.Now().Add(config.timeout))
+		if err := socket.Handshake(); err != nil {
+			emit("Failed to tls handshake with %s %s\n", address, err)
+			retry.Wait()
+			socket.Close()
+			continue
+		}
+
+		emit("Connected to syslog server %s\n", address)
+		return socket
+	}
+}
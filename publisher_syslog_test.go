@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSyslogHeader(t *testing.T) {
+	fileconfig := FileConfig{
+		SyslogFacility: "local3",
+		SyslogSeverity: "warning",
+		SyslogAppName:  "nginx",
+		SyslogStructuredData: map[string]map[string]string{
+			"meta@32473": {"env": "prod", "dc": `eu"1]`},
+			"app@32473":  {"tier": "web"},
+		},
+	}
+
+	header, err := newSyslogHeader(&fileconfig)
+	chkerr(t, err)
+
+	if header.pri != 19*8+4 {
+		t.Fatalf("Expected pri %d, got %d", 19*8+4, header.pri)
+	}
+	expected := `[app@32473 tier="web"][meta@32473 dc="eu\"1\]" env="prod"]`
+	if header.structuredData != expected {
+		t.Fatalf("Expected structured data %s, got %s", expected, header.structuredData)
+	}
+}
+
+func TestSyslogHeaderInvalid(t *testing.T) {
+	invalid := []FileConfig{
+		{SyslogFacility: "local9"},
+		{SyslogSeverity: "loud"},
+		{SyslogAppName: "my app"},
+		{SyslogStructuredData: map[string]map[string]string{"bad id": {"a": "b"}}},
+		{SyslogStructuredData: map[string]map[string]string{"ok@1": {"a=b": "c"}}},
+	}
+
+	for _, fileconfig := range invalid {
+		if _, err := newSyslogHeader(&fileconfig); err == nil {
+			t.Errorf("Expected an error for %+v", fileconfig)
+		}
+	}
+}
+
+func TestFormatSyslogFrame(t *testing.T) {
+	hostname = "myhost"
+	source, text := "/var/log/app.log", "hello world"
+	fileconfig := FileConfig{}
+	fileconfig.syslog, _ = newSyslogHeader(&fileconfig)
+	event := &FileEvent{Source: &source, Text: &text, fileconfig: &fileconfig}
+
+	var buffer bytes.Buffer
+	timestamp := time.Date(2014, 7, 4, 1, 20, 23, 5000, time.UTC)
+	formatSyslogFrame(event, timestamp, &buffer)
+
+	msg := "<14>1 2014-07-04T01:20:23.000005Z myhost logstash-forwarder - - - hello world"
+	expected := "77 " + msg
+	if buffer.String() != expected {
+		t.Fatalf("Expected\n%q\ngot\n%q", expected, buffer.String())
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The limit applying to the lines of every file together, if any.
+var globalLimiter *rateLimiter
+
+const defaultSampleRate = 10
+
+// A token bucket refilling at rate tokens a second, holding at most one
+// second's worth. Taking more than it holds puts it in debt, so a line larger
+// than the bucket still passes once it is full.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+}
+
+// How long until n tokens can be taken, 0 if they can be now.
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if b == nil {
+		return 0
+	}
+	if n > b.rate {
+		n = b.rate
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b != nil {
+		b.tokens -= n
+	}
+}
+
+// Limits the lines and bytes a second shipped from one file entry, or from
+// all of them. Lines over the limit are held back ("block"), one in every
+// Sample of them is shipped ("sample"), or none are ("drop").
+type rateLimiter struct {
+	sync.Mutex
+	lines    *tokenBucket
+	bytes    *tokenBucket
+	policy   string
+	sample   uint64
+	over     uint64        /* lines over the limit, for sampling */
+	interval time.Duration /* between summaries of suppressed lines */
+}
+
+// Builds the limiter for the settings, or nil if they set no limit.
+func newRateLimiter(config *RateLimitConfig) (*rateLimiter, error) {
+	if config.Lines < 0 {
+		return nil, settingErrorf("lines", "rate limits must not be negative")
+	}
+	if config.Bytes < 0 {
+		return nil, settingErrorf("bytes", "rate limits must not be negative")
+	}
+	if config.Lines == 0 && config.Bytes == 0 {
+		if config.Policy != "" || config.Sample != 0 || config.SummaryInterval != "" {
+			return nil, settingErrorf("", "rate limit settings given without lines or bytes a second")
+		}
+		return nil, nil
+	}
+
+	limiter := &rateLimiter{
+		lines:  newTokenBucket(config.Lines),
+		bytes:  newTokenBucket(config.Bytes),
+		policy: config.Policy,
+		sample: config.Sample,
+	}
+	switch limiter.policy {
+	case "":
+		limiter.policy = "block"
+	case "block", "drop":
+	case "sample":
+		if limiter.sample == 0 {
+			limiter.sample = defaultSampleRate
+		}
+	default:
+		return nil, settingErrorf("policy", "unknown rate limit policy '%s', expected 'block', 'sample' or 'drop'", config.Policy)
+	}
+	if config.Sample != 0 && limiter.policy != "sample" {
+		return nil, settingErrorf("sample", "a rate limit sample is only used by the 'sample' policy")
+	}
+
+	limiter.interval = defaultConfig.summaryInterval
+	if config.SummaryInterval != "" {
+		var err error
+		if limiter.interval, err = time.ParseDuration(config.SummaryInterval); err != nil {
+			return nil, settingErrorf("summary interval", "invalid rate limit summary interval: %s", err)
+		}
+	}
+	return limiter, nil
+}
+
+// Whether a line of size bytes may be shipped, blocking until it can if
+// that is the policy.
+func (l *rateLimiter) Allow(size int) bool {
+	return allowLine(size, l) == nil
+}
+
+// Asks every limiter whether a line of size bytes may be shipped, and
+// returns the first that won't let it, or nil once all do. Tokens are only
+// taken when the line is shipped, so a line one limiter suppresses doesn't
+// count against the others. Nil limiters are left out.
+func allowLine(size int, limiters ...*rateLimiter) *rateLimiter {
+	var active []*rateLimiter
+	for _, l := range limiters {
+		if l != nil {
+			active = append(active, l)
+		}
+	}
+	waits := make([]time.Duration, len(active))
+	for {
+		// Locked in the order given, file limiters before the global one
+		for _, l := range active {
+			l.Lock()
+		}
+		now := time.Now()
+		var block time.Duration
+		var refused *rateLimiter
+		for i, l := range active {
+			if l.lines != nil {
+				l.lines.refill(now)
+			}
+			if l.bytes != nil {
+				l.bytes.refill(now)
+			}
+			waits[i] = l.lines.wait(1)
+			if w := l.bytes.wait(float64(size)); w > waits[i] {
+				waits[i] = w
+			}
+			if waits[i] > 0 && l.policy == "drop" && refused == nil {
+				refused = l
+			}
+			if waits[i] > block && l.policy == "block" {
+				block = waits[i]
+			}
+		}
+		if refused == nil && block == 0 {
+			break
+		}
+		for _, l := range active {
+			l.Unlock()
+		}
+		if refused != nil {
+			return refused
+		}
+		// Unlocked while waiting, so one file held back by its own limit
+		// doesn't hold back the others
+		time.Sleep(block)
+	}
+	defer func() {
+		for _, l := range active {
+			l.Unlock()
+		}
+	}()
+
+	for i, l := range active {
+		if waits[i] > 0 {
+			// Only sampling limiters are left over their limit
+			l.over++
+			if (l.over-1)%l.sample != 0 {
+				return l
+			}
+		}
+	}
+	for i, l := range active {
+		if waits[i] == 0 {
+			l.lines.take(1)
+			l.bytes.take(float64(size))
+		}
+	}
+	return nil
+}
+
+// Sets the limit on all files together.
+func configureRateLimit(config *Config) (err error) {
+	globalLimiter, err = newRateLimiter(&config.RateLimit)
+	if err != nil {
+		return fmt.Errorf("Invalid rate limit: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterPolicies(t *testing.T) {
+	limiter, err := newRateLimiter(&RateLimitConfig{Lines: 5, Policy: "drop"})
+	chkerr(t, err)
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		if limiter.Allow(10) {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Fatalf("Expected a burst of 5 lines to be allowed, got %d", allowed)
+	}
+
+	limiter, err = newRateLimiter(&RateLimitConfig{Bytes: 100, Policy: "sample", Sample: 4})
+	chkerr(t, err)
+	allowed = 0
+	for i := 0; i < 20; i++ {
+		if limiter.Allow(50) {
+			allowed++
+		}
+	}
+	// two lines fit in the bucket, then one in four of the other 18
+	if allowed != 2+5 {
+		t.Fatalf("Expected 7 lines to be allowed, got %d", allowed)
+	}
+
+	limiter, err = newRateLimiter(&RateLimitConfig{Lines: 20})
+	chkerr(t, err)
+	started := time.Now()
+	for i := 0; i < 22; i++ {
+		if !limiter.Allow(10) {
+			t.Fatalf("Expected the block policy to allow every line")
+		}
+	}
+	if elapsed := time.Since(started); elapsed < 80*time.Millisecond {
+		t.Fatalf("Expected lines over the limit to be held back, took %v", elapsed)
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	limiter, err := newRateLimiter(&RateLimitConfig{})
+	chkerr(t, err)
+	if limiter != nil {
+		t.Fatalf("Expected no limiter without limits")
+	}
+
+	limiter, err = newRateLimiter(&RateLimitConfig{Lines: 100, Policy: "sample", SummaryInterval: "30s"})
+	chkerr(t, err)
+	if limiter.policy != "sample" || limiter.sample != defaultSampleRate || limiter.interval != 30*time.Second {
+		t.Fatalf("Expected sampling one in %d with a 30s summary, got %+v", defaultSampleRate, limiter)
+	}
+
+	for _, config := range []RateLimitConfig{
+		{Lines: -1},
+		{Policy: "drop"},
+		{Lines: 10, Policy: "throttle"},
+		{Lines: 10, Policy: "drop", Sample: 5},
+		{Lines: 10, SummaryInterval: "often"},
+	} {
+		if _, err := newRateLimiter(&config); err == nil {
+			t.Errorf("Expected %+v to be an error", config)
+		}
+	}
+}
+
+func TestRateLimitersTogether(t *testing.T) {
+	file, err := newRateLimiter(&RateLimitConfig{Lines: 10, Policy: "drop"})
+	chkerr(t, err)
+	global, err := newRateLimiter(&RateLimitConfig{Lines: 2, Policy: "drop"})
+	chkerr(t, err)
+
+	for i := 0; i < 5; i++ {
+		refused := allowLine(10, file, nil, global)
+		if i < 2 && refused != nil {
+			t.Fatalf("Expected line %d to be allowed", i)
+		} else if i >= 2 && refused != global {
+			t.Fatalf("Expected line %d to be refused by the global limit", i)
+		}
+	}
+	// Lines the global limit suppressed don't count against the file's
+	if tokens := file.lines.tokens; tokens < 7.9 {
+		t.Fatalf("Expected only the 2 lines shipped to be taken from the file's limit, %.1f tokens are left", tokens)
+	}
+}
@@ -7,24 +7,42 @@ import (
 
 func Registrar(state map[string]*FileState, input chan []*FileEvent) {
 	for events := range input {
+		exiting := false
 		emit ("Registrar: processing %d events\n", len(events))
 		// Take the last event found for each file source
 		for _, event := range events {
-			// skip stdin
-			if *event.Source == "-" {
+			// Only record an event once every output it was routed to has
+			// acknowledged it
+			event.pending--
+			if event.pending > 0 {
+				continue
+			}
+
+			// Everything read from standard input before its end has been
+			// acknowledged too
+			if event.exit {
+				exiting = true
+			}
+
+			// skip stdin, pipes and sockets, which have no offsets
+			if *event.Source == "-" || event.fileconfig.Input != "file" {
 				continue
 			}
 
 			ino, dev := file_ids(event.fileinfo)
-			state[*event.Source] = &FileState{
+			filestate := &FileState{
 				Source: event.Source,
-				// take the offset + length of the line + newline char and
-				// save it as the new starting offset.
-				// This issues a problem, if the EOL is a CRLF! Then on start it read the LF again and generates a event with an empty line
-				Offset: event.Offset + int64(len(*event.Text)) + 1, // REVU: this is begging for BUGs
+				// save where the next line starts, past this line and its
+				// newline, or past the lines dropped before a marker
+				Offset: event.nextOffset,
 				Inode:  ino,
 				Device: dev,
 			}
+			if event.fingerprint != nil {
+				filestate.Fingerprint = event.fingerprint.sum
+				filestate.FingerprintSize = event.fingerprint.size
+			}
+			state[*event.Source] = filestate
 			//log.Printf("State %s: %d\n", *event.Source, event.Offset)
 		}
 
@@ -32,6 +50,9 @@ func Registrar(state map[string]*FileState, input chan []*FileEvent) {
 			// REVU: but we should panic, or something, right?
 			emit("WARNING: (continuing) update of registry returned error: %s", e)
 		}
+		if exiting {
+			exit(exitStat.ok, "Standard input has been read and shipped, exiting")
+		}
 	}
 }
 
@@ -0,0 +1,56 @@
+package main
+
+// RouteFiles connects each files entry to the spooler of every output it
+// routes to, returning the channel each entry's events are to be sent on.
+// Every output has its own scheduler, taking from the entries routed to it by
+// priority, so an output that stalls only holds back those entries.
+func RouteFiles(files []FileConfig, spools map[string]chan *FileEvent) []chan *FileEvent {
+	inputs := make(map[string][]chan *FileEvent)
+	priorities := make(map[string][]int)
+	entries := make([]chan *FileEvent, len(files))
+	for i, fileconfig := range files {
+		entries[i] = make(chan *FileEvent, 16)
+		routed := make(map[string]chan *FileEvent)
+		for _, name := range fileconfig.Outputs {
+			if _, exists := routed[name]; exists {
+				continue
+			}
+			routed[name] = make(chan *FileEvent, 16)
+			inputs[name] = append(inputs[name], routed[name])
+			priorities[name] = append(priorities[name], fileconfig.Priority)
+		}
+		go Route(entries[i], routed)
+	}
+	for name, spool := range spools {
+		go Schedule(inputs[name], priorities[name], spool)
+	}
+	return entries
+}
+
+// Route sends each event of a files entry to every output it routes to. The
+// registrar counts down event.pending as outputs acknowledge the event.
+func Route(input chan *FileEvent, outputs map[string]chan *FileEvent) {
+	for event := range input {
+		event.pending = len(event.fileconfig.Outputs)
+		for _, name := range event.fileconfig.Outputs {
+			outputs[name] <- event
+		}
+	}
+}
+
+// Publish runs the publisher for the type of output.
+func Publish(output *OutputConfig, input chan []*FileEvent, registrar chan []*FileEvent) {
+	switch output.Type {
+	case "network":
+		switch output.Network.Protocol {
+		case "lumberjack":
+			Publishv1(input, registrar, &output.Network)
+		case "syslog":
+			PublishSyslog(input, registrar, &output.Network)
+		}
+	case "stdout":
+		PublishStdout(input, registrar, output)
+	case "file":
+		PublishFile(input, registrar, output)
+	}
+}
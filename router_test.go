@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoute(t *testing.T) {
+	input := make(chan *FileEvent, 1)
+	outputs := map[string]chan *FileEvent{
+		"siem":     make(chan *FileEvent, 1),
+		"logstash": make(chan *FileEvent, 1),
+	}
+	go Route(input, outputs)
+
+	audit := testEvent("/var/log/audit.log", "audit", 0, map[string]string{})
+	audit.fileconfig = &FileConfig{Outputs: []string{"siem", "logstash"}}
+	debug := testEvent("/var/log/debug.log", "debug", 0, map[string]string{})
+	debug.fileconfig = &FileConfig{Outputs: []string{"logstash"}}
+
+	input <- audit
+	if <-outputs["siem"] != audit || <-outputs["logstash"] != audit {
+		t.Fatalf("Expected the audit event to go to both outputs")
+	}
+	if audit.pending != 2 {
+		t.Fatalf("Expected the audit event to wait on 2 outputs, got %d", audit.pending)
+	}
+
+	input <- debug
+	if <-outputs["logstash"] != debug {
+		t.Fatalf("Expected the debug event to go to logstash")
+	}
+	if len(outputs["siem"]) != 0 {
+		t.Fatalf("Expected the debug event not to go to the siem")
+	}
+}
+
+func TestRouteFilesStalledOutput(t *testing.T) {
+	spools := map[string]chan *FileEvent{
+		"siem":     make(chan *FileEvent), /* never taken from */
+		"logstash": make(chan *FileEvent, 1),
+	}
+	audit := &FileConfig{Outputs: []string{"siem", "logstash"}}
+	debug := &FileConfig{Outputs: []string{"logstash"}}
+	entries := RouteFiles([]FileConfig{*audit, *debug}, spools)
+
+	// Fill everything between the audit entry and the stalled siem
+	go func() {
+		for i := 0; i < 100; i++ {
+			event := testEvent("/var/log/audit.log", "audit", 0, map[string]string{})
+			event.fileconfig = audit
+			entries[0] <- event
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for i := 0; i < 10; i++ {
+		event := testEvent("/var/log/debug.log", "debug", 0, map[string]string{})
+		event.fileconfig = debug
+		select {
+		case entries[1] <- event:
+		case <-timeout:
+			t.Fatalf("Expected the debug entry not to be held back by the siem")
+		}
+	}
+	for n := 0; n < 10; {
+		select {
+		case event := <-spools["logstash"]:
+			if event.fileconfig == debug {
+				n++
+			}
+		case <-timeout:
+			t.Fatalf("Expected every debug event to reach logstash, got %d", n)
+		}
+	}
+}
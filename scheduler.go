@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Schedule forwards the events of each file entry to output in weighted
+// round-robin: each turn takes up to priorities[i] events waiting on
+// inputs[i]. A backlogged entry so only holds up the others for its share of
+// each turn, rather than for as long as it keeps its harvesters busy. Within
+// an entry, harvesterLanes gives each of its files a turn in the same way.
+func Schedule(inputs []chan *FileEvent, priorities []int, output chan *FileEvent) {
+	cases := make([]reflect.SelectCase, len(inputs))
+	for i, input := range inputs {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(input)}
+	}
+
+	for {
+		sent := false
+		for i, input := range inputs {
+		Turn:
+			for n := 0; n < priorities[i]; n++ {
+				select {
+				case event := <-input:
+					output <- event
+					sent = true
+				default:
+					break Turn
+				}
+			}
+		}
+
+		if !sent {
+			// Nothing is waiting anywhere, so wait for whichever comes first
+			_, event, ok := reflect.Select(cases)
+			if !ok {
+				return
+			}
+			output <- event.Interface().(*FileEvent)
+		}
+	}
+}
+
+// The lanes the harvesters of one files entry send on, one each, which run
+// forwards to the entry's channel in turn. A file written to faster than it
+// can be shipped so only gets its share of the entry's turns, rather than
+// holding up the other files of the entry.
+type harvesterLanes struct {
+	sync.Mutex
+	lanes []chan *FileEvent
+	added chan bool /* signalled when a lane is added */
+}
+
+func newHarvesterLanes() *harvesterLanes {
+	return &harvesterLanes{added: make(chan bool, 1)}
+}
+
+// Adds a lane for a harvester, which closes it once it has stopped.
+func (l *harvesterLanes) lane() chan *FileEvent {
+	lane := make(chan *FileEvent, 16)
+	l.Lock()
+	l.lanes = append(l.lanes, lane)
+	l.Unlock()
+	select {
+	case l.added <- true:
+	default:
+	}
+	return lane
+}
+
+// Takes an event from each lane in turn, sending them to output. Lanes are
+// dropped once closed.
+func (l *harvesterLanes) run(output chan *FileEvent) {
+	for {
+		l.Lock()
+		lanes := append([]chan *FileEvent(nil), l.lanes...)
+		l.Unlock()
+
+		sent := false
+		for _, lane := range lanes {
+			select {
+			case event, ok := <-lane:
+				if !ok {
+					l.drop(lane)
+					continue
+				}
+				output <- event
+				sent = true
+			default:
+			}
+		}
+
+		if !sent {
+			// Nothing is waiting on any lane, so wait for whichever comes
+			// first, or for a lane to be added
+			cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(l.added)}}
+			for _, lane := range lanes {
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(lane)})
+			}
+			chosen, event, ok := reflect.Select(cases)
+			if chosen == 0 {
+				continue
+			}
+			if !ok {
+				l.drop(lanes[chosen-1])
+				continue
+			}
+			output <- event.Interface().(*FileEvent)
+		}
+	}
+}
+
+func (l *harvesterLanes) drop(lane chan *FileEvent) {
+	l.Lock()
+	defer l.Unlock()
+	for i := range l.lanes {
+		if l.lanes[i] == lane {
+			l.lanes = append(l.lanes[:i], l.lanes[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSchedule(t *testing.T) {
+	access := make(chan *FileEvent, 16)
+	audit := make(chan *FileEvent, 16)
+	for i := 0; i < 6; i++ {
+		access <- testEvent("/var/log/access.log", "access", int64(i), map[string]string{})
+	}
+	for i := 0; i < 4; i++ {
+		audit <- testEvent("/var/log/audit.log", "audit", int64(i), map[string]string{})
+	}
+
+	output := make(chan *FileEvent)
+	go Schedule([]chan *FileEvent{access, audit}, []int{1, 2}, output)
+
+	var order string
+	for i := 0; i < 10; i++ {
+		order += (*(<-output).Text)[:2]
+	}
+	if expected := "acauauacauauacacacac"; order != expected {
+		t.Fatalf("Expected events in the order %s, got %s", expected, order)
+	}
+
+	// Once everything is drained, the scheduler waits for the next event
+	audit <- testEvent("/var/log/audit.log", "audit", 4, map[string]string{})
+	if event := <-output; event.Offset != 4 {
+		t.Fatalf("Expected the next audit event, got %v", event)
+	}
+}
+
+func TestHarvesterLanes(t *testing.T) {
+	// Several files under one entry, one of them far busier than the others
+	lanes := newHarvesterLanes()
+	noisy, app, audit := lanes.lane(), lanes.lane(), lanes.lane()
+	for i := 0; i < 6; i++ {
+		noisy <- testEvent("/var/log/noisy.log", "noisy", int64(i), map[string]string{})
+	}
+	app <- testEvent("/var/log/app.log", "app", 0, map[string]string{})
+	audit <- testEvent("/var/log/audit.log", "audit", 0, map[string]string{})
+	audit <- testEvent("/var/log/audit.log", "audit", 1, map[string]string{})
+	close(app)
+
+	output := make(chan *FileEvent)
+	go lanes.run(output)
+
+	var order string
+	for i := 0; i < 9; i++ {
+		order += (*(<-output).Text)[:2]
+	}
+	if expected := "noapaunoaunononono"; order != expected {
+		t.Fatalf("Expected events in the order %s, got %s", expected, order)
+	}
+
+	// A closed lane is dropped, and one added later takes its turns
+	late := lanes.lane()
+	late <- testEvent("/var/log/late.log", "late", 0, map[string]string{})
+	if event := <-output; *event.Text != "late" {
+		t.Fatalf("Expected the event of the lane added later, got %s", *event.Text)
+	}
+	lanes.Lock()
+	defer lanes.Unlock()
+	if len(lanes.lanes) != 3 {
+		t.Fatalf("Expected the closed lane to be dropped, got %d lanes", len(lanes.lanes))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Where harvesting starts in a file with no recorded offset: "beginning",
+// "end", "last N lines" or "last N bytes". Starting from the last bytes
+// skips ahead to the first whole line among them.
+type startPosition struct {
+	name  string
+	unit  string /* "lines" or "bytes" for the last count of them */
+	count int64
+}
+
+var startPosition_re = regexp.MustCompile(`^last ([0-9]+) (lines|bytes)$`)
+
+func parseStartPosition(text string) (*startPosition, error) {
+	switch text {
+	case "beginning", "end":
+		return &startPosition{name: text}, nil
+	}
+	if submatch := startPosition_re.FindStringSubmatch(text); submatch != nil {
+		count, err := strconv.ParseInt(submatch[1], 10, 64)
+		if err == nil {
+			return &startPosition{name: text, unit: submatch[2], count: count}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid start position '%s', expected 'beginning', 'end', 'last N lines' or 'last N bytes'", text)
+}
+
+// The offset in file to start harvesting from.
+func (p *startPosition) find(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	switch {
+	case p.name == "beginning":
+		return 0, nil
+	case p.name == "end":
+		return size, nil
+	case p.unit == "bytes":
+		if p.count >= size {
+			return 0, nil
+		}
+		return nextLineStart(file, size-p.count, size)
+	}
+	return lastLinesStart(file, p.count, size)
+}
+
+// The offset of the first line starting at or after offset.
+func nextLineStart(file *os.File, offset, size int64) (int64, error) {
+	if offset == 0 {
+		return 0, nil
+	}
+	chunk := make([]byte, 4096)
+	// The line starts at offset if the byte before it ends a line
+	for at := offset - 1; at < size; at += int64(len(chunk)) {
+		n, err := file.ReadAt(chunk, at)
+		if i := bytes.IndexByte(chunk[:n], '\n'); i >= 0 {
+			return at + int64(i) + 1, nil
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return size, nil
+}
+
+// The offset of the start of the last count lines, scanning backwards from
+// the end for newlines. A last line without a newline yet counts as one.
+func lastLinesStart(file *os.File, count, size int64) (int64, error) {
+	if count == 0 {
+		return size, nil
+	}
+	chunk := make([]byte, 4096)
+	end := size
+	last := make([]byte, 1)
+	if size > 0 {
+		if _, err := file.ReadAt(last, size-1); err != nil {
+			return 0, err
+		}
+		if last[0] == '\n' {
+			// This newline ends the last line, rather than starting one
+			end--
+		}
+	}
+
+	for end > 0 {
+		start := end - int64(len(chunk))
+		if start < 0 {
+			start = 0
+		}
+		data := chunk[:end-start]
+		if _, err := file.ReadAt(data, start); err != nil {
+			return 0, err
+		}
+		for i := len(data) - 1; i >= 0; i-- {
+			if data[i] == '\n' {
+				if count--; count == 0 {
+					return start + int64(i) + 1, nil
+				}
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestStartPosition(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+	logfile := path.Join(tmpdir, "app.log")
+
+	// Long enough to need more than one chunk scanning backwards
+	long := strings.Repeat("x", 5000)
+	contents := "one\ntwo\n" + long + "\nfour\nfive\n"
+	chkerr(t, ioutil.WriteFile(logfile, []byte(contents), 0644))
+	file, err := os.Open(logfile)
+	chkerr(t, err)
+	defer file.Close()
+
+	for position, expected := range map[string]string{
+		"beginning":       contents,
+		"end":             "",
+		"last 0 lines":    "",
+		"last 2 lines":    "four\nfive\n",
+		"last 3 lines":    long + "\nfour\nfive\n",
+		"last 10 lines":   contents,
+		"last 8 bytes":    "five\n",
+		"last 9 bytes":    "five\n",
+		"last 10 bytes":   "four\nfive\n",
+		"last 5010 bytes": "four\nfive\n",
+		"last 5011 bytes": long + "\nfour\nfive\n",
+		"last 9999 bytes": contents,
+	} {
+		p, err := parseStartPosition(position)
+		chkerr(t, err)
+		offset, err := p.find(file)
+		chkerr(t, err)
+		if contents[offset:] != expected {
+			t.Errorf("Expected %s to start at %q, got %q", position, expected, contents[offset:])
+		}
+	}
+
+	// A last line still being written counts as a line
+	chkerr(t, ioutil.WriteFile(logfile, []byte("one\ntwo\nthr"), 0644))
+	p, _ := parseStartPosition("last 2 lines")
+	if offset, _ := p.find(file); offset != 4 {
+		t.Errorf("Expected the last 2 lines to start at 4, got %d", offset)
+	}
+
+	for _, position := range []string{"", "start", "last lines", "last -1 lines", "last 5 kb"} {
+		if _, err := parseStartPosition(position); err == nil {
+			t.Errorf("Expected %q to be an error", position)
+		}
+	}
+}
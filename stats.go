@@ -0,0 +1,44 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Process-wide counters, such as bytes published, which are periodically
+// written to the log.
+var stats = struct {
+	sync.Mutex
+	counters map[string]uint64
+}{
+	counters: make(map[string]uint64),
+}
+
+func countStat(name string, delta uint64) {
+	stats.Lock()
+	stats.counters[name] += delta
+	stats.Unlock()
+}
+
+func readStat(name string) uint64 {
+	stats.Lock()
+	defer stats.Unlock()
+	return stats.counters[name]
+}
+
+// ReportStats emits every counter, sorted by name, once per interval.
+func ReportStats(interval time.Duration) {
+	for range time.Tick(interval) {
+		stats.Lock()
+		names := make([]string, 0, len(stats.counters))
+		for name := range stats.counters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			emit("stats: %s = %d\n", name, stats.counters[name])
+		}
+		stats.Unlock()
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Reads lines from an input that isn't a file: standard input ("-"), a named
+// pipe, or a unix stream or datagram socket that local applications write to.
+// There is no offset to resume them from, so nothing is recorded in the
+// registry for them.
+type StreamHarvester struct {
+	Path       string /* the pipe or socket, or "-" for standard input */
+	FileConfig FileConfig
+	Input      string /* "stdin", "pipe", "unix" or "unixgram" */
+}
+
+// Checks the input of a files entry, and what it does at the end of one.
+func checkInput(fileconfig *FileConfig) error {
+	switch fileconfig.Input {
+	case "", "file", "pipe", "unix", "unixgram":
+	case "stdin":
+		for i, path := range fileconfig.Paths {
+			if path != "-" {
+				return settingErrorf(fmt.Sprintf("paths[%d]", i), "the path of standard input is '-', not '%s'", path)
+			}
+		}
+	default:
+		return settingErrorf("input", "unknown input '%s', expected 'file', 'stdin', 'pipe', 'unix' or 'unixgram'", fileconfig.Input)
+	}
+
+	switch fileconfig.OnEOF {
+	case "", "stop":
+	case "exit":
+		// Only standard input comes to an end
+		stdin := fileconfig.Input == "stdin"
+		for _, path := range fileconfig.Paths {
+			stdin = stdin || path == "-"
+		}
+		if !stdin {
+			return settingErrorf("on eof", "on eof 'exit' is only for standard input")
+		}
+	default:
+		return settingErrorf("on eof", "unknown on eof '%s', expected 'stop' or 'exit'", fileconfig.OnEOF)
+	}
+	return nil
+}
+
+func (s *StreamHarvester) Harvest(output chan *FileEvent) {
+	switch s.Input {
+	case "stdin":
+		s.harvestStdin(output)
+	case "pipe":
+		s.harvestPipe(output)
+	case "unix":
+		s.harvestStreamSocket(output)
+	case "unixgram":
+		s.harvestDatagramSocket(output)
+	}
+}
+
+// Reads standard input to its end, then either stops or, with "on eof" set
+// to "exit", has the forwarder exit once everything read was acknowledged.
+func (s *StreamHarvester) harvestStdin(output chan *FileEvent) {
+	emit("harvest: reading standard input\n")
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		emit("Failed to stat standard input: %s\n", err)
+		return
+	}
+	lines := s.lines(&info)
+	if err := lines.read(os.Stdin, output); err != nil {
+		emit("Unexpected state reading standard input; error: %s\n", err)
+	}
+
+	if s.FileConfig.OnEOF == "exit" {
+		emit("Finished reading standard input, exiting once it has been shipped\n")
+		event := lines.h.marker(&info)
+		event.exit = true
+		output <- event
+		return
+	}
+	emit("Finished reading standard input\n")
+}
+
+// Reads a named pipe from each writer in turn. Reading gets to the end once
+// every writer has closed it, and opening it again waits for the next one.
+func (s *StreamHarvester) harvestPipe(output chan *FileEvent) {
+	for {
+		file, err := os.Open(s.Path)
+		if err != nil {
+			emit("Failed opening %s: %s\n", s.Path, err)
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		info, err := file.Stat()
+		if err != nil || info.Mode()&os.ModeNamedPipe == 0 {
+			emit("Stopping harvest of %s; it is not a named pipe\n", s.Path)
+			file.Close()
+			return
+		}
+
+		emit("harvest: reading named pipe %s\n", s.Path)
+		if err := s.lines(&info).read(file, output); err != nil {
+			emit("Unexpected state reading from %s; error: %s\n", s.Path, err)
+		}
+		file.Close()
+		emit("Writers closed %s, reopening it\n", s.Path)
+	}
+}
+
+// Accepts connections on a unix stream socket, reading lines from each
+// until the writer closes it.
+func (s *StreamHarvester) harvestStreamSocket(output chan *FileEvent) {
+	closer, info := s.listen(func() (io.Closer, error) { return net.Listen("unix", s.Path) })
+	listener := closer.(net.Listener)
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			emit("Failed accepting a connection on %s: %s\n", s.Path, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			if err := s.lines(&info).read(conn, output); err != nil {
+				emit("Unexpected state reading from %s; error: %s\n", s.Path, err)
+			}
+		}()
+	}
+}
+
+// Receives datagrams on a unix datagram socket. Each datagram holds one or
+// more lines.
+func (s *StreamHarvester) harvestDatagramSocket(output chan *FileEvent) {
+	closer, info := s.listen(func() (io.Closer, error) { return net.ListenPacket("unixgram", s.Path) })
+	conn := closer.(net.PacketConn)
+	defer conn.Close()
+
+	lines := s.lines(&info)
+	datagram := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(datagram)
+		if err != nil {
+			emit("Failed receiving on %s: %s\n", s.Path, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		text := strings.TrimSuffix(string(datagram[:n]), "\n")
+		for _, line := range strings.Split(text, "\n") {
+			lines.ship(strings.TrimSuffix(line, "\r"), len(line)+1, output)
+		}
+	}
+}
+
+// Listens on the socket at the path, retrying until it can. A socket left
+// behind by an earlier run is removed first, but nothing else is.
+func (s *StreamHarvester) listen(listen func() (io.Closer, error)) (io.Closer, os.FileInfo) {
+	for {
+		if info, err := os.Lstat(s.Path); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(s.Path)
+		}
+		listener, err := listen()
+		if err == nil {
+			var info os.FileInfo
+			if info, err = os.Stat(s.Path); err == nil {
+				emit("harvest: listening on %s socket %s\n", s.Input, s.Path)
+				return listener, info
+			}
+			listener.Close()
+		}
+		emit("Failed listening on %s: %s\n", s.Path, err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (s *StreamHarvester) lines(info *os.FileInfo) *streamLines {
+	return &streamLines{
+		h:                 &Harvester{Path: s.Path, FileConfig: s.FileConfig},
+		info:              info,
+		last_summary_time: time.Now(),
+	}
+}
+
+// Ships lines read from one stream through the files entry's line filter,
+// rate limits and processors, as a harvester does for a file.
+type streamLines struct {
+	h    *Harvester
+	info *os.FileInfo
+	line uint64
+
+	suppressed        uint64
+	summary_interval  time.Duration
+	last_summary_time time.Time
+}
+
+// Ships every line read until the end of reader. A last line without a
+// newline is shipped too, since nothing more will be written to it.
+func (l *streamLines) read(reader io.Reader, output chan *FileEvent) error {
+	buffered := bufio.NewReaderSize(reader, options.harvesterBufferSize)
+	for {
+		text, err := buffered.ReadString('\n')
+		if text != "" {
+			size := len(text)
+			text = strings.TrimSuffix(strings.TrimSuffix(text, "\n"), "\r")
+			l.ship(text, size, output)
+		}
+		if err == io.EOF {
+			l.summarize(output, 0)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (l *streamLines) ship(text string, size int, output chan *FileEvent) {
+	h := l.h
+	l.line++
+	if h.FileConfig.filter != nil && !h.FileConfig.filter.Keep(text) {
+		countStat("file."+h.Path+".lines.dropped", 1)
+		return
+	}
+	if limiter := h.limit(size); limiter != nil {
+		countStat("file."+h.Path+".lines.suppressed", 1)
+		l.suppressed++
+		l.summary_interval = limiter.interval
+		l.summarize(output, l.summary_interval)
+		return
+	}
+	l.summarize(output, l.summary_interval)
+
+	output <- h.event(&text, l.line, l.info, time.Now(), 0)
+}
+
+// Sends a summary of the lines a rate limit suppressed, once interval has
+// passed since the last.
+func (l *streamLines) summarize(output chan *FileEvent, interval time.Duration) {
+	if l.suppressed > 0 && time.Since(l.last_summary_time) >= interval {
+		output <- l.h.summary(l.info, l.suppressed)
+		l.suppressed = 0
+		l.last_summary_time = time.Now()
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckInput(t *testing.T) {
+	for _, fileconfig := range []FileConfig{
+		{Paths: []string{"/var/log/*.log"}},
+		{Paths: []string{"-"}, Input: "stdin", OnEOF: "exit"},
+		{Paths: []string{"/run/app.pipe"}, Input: "pipe"},
+		{Paths: []string{"/run/app.sock"}, Input: "unixgram"},
+		{Paths: []string{"-", "/var/log/*.log"}, OnEOF: "exit"},
+	} {
+		if err := checkInput(&fileconfig); err != nil {
+			t.Errorf("Expected input %q to be valid, got %s", fileconfig.Input, err)
+		}
+	}
+	for _, fileconfig := range []FileConfig{
+		{Paths: []string{"/dev/stdin"}, Input: "stdin"},
+		{Paths: []string{"/run/app.sock"}, Input: "tcp"},
+		{Paths: []string{"-"}, Input: "stdin", OnEOF: "wait"},
+		{Paths: []string{"/run/app.pipe"}, Input: "pipe", OnEOF: "exit"},
+		{Paths: []string{"/run/app.sock"}, Input: "unix", OnEOF: "exit"},
+		{Paths: []string{"/var/log/*.log"}, OnEOF: "exit"},
+	} {
+		if err := checkInput(&fileconfig); err == nil {
+			t.Errorf("Expected %v to be an invalid input", fileconfig)
+		}
+	}
+}
+
+func TestStreamLines(t *testing.T) {
+	s := &StreamHarvester{Path: "-", Input: "stdin"}
+	lines := s.lines(nil)
+	output := make(chan *FileEvent, 10)
+	chkerr(t, lines.read(strings.NewReader("one\r\ntwo\n\nfour"), output))
+	close(output)
+
+	expected := []string{"one", "two", "", "four"}
+	for i, text := range expected {
+		event := <-output
+		if event == nil || *event.Text != text || event.Line != uint64(i+1) {
+			t.Fatalf("Expected line %d to be %q, got %v", i+1, text, event)
+		}
+	}
+	if event := <-output; event != nil {
+		t.Fatalf("Expected no more lines, got %q", *event.Text)
+	}
+}
+
+func TestStreamSockets(t *testing.T) {
+	tmpdir := makeTempDir(t)
+	defer rmTempDir(tmpdir)
+
+	for _, input := range []string{"unix", "unixgram"} {
+		socket := path.Join(tmpdir, input+".sock")
+		output := make(chan *FileEvent, 10)
+		go (&StreamHarvester{Path: socket, Input: input}).Harvest(output)
+		for i := 0; ; i++ {
+			if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+				break
+			} else if i > 100 {
+				t.Fatalf("Expected a %s socket at %s", input, socket)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		conn, err := net.Dial(input, socket)
+		chkerr(t, err)
+		_, err = conn.Write([]byte("first\nsecond\n"))
+		chkerr(t, err)
+		conn.Close()
+
+		for _, text := range []string{"first", "second"} {
+			select {
+			case event := <-output:
+				if *event.Text != text {
+					t.Fatalf("Expected %q from the %s socket, got %q", text, input, *event.Text)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Expected %q from the %s socket", text, input)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Finds and parses the time an event happened from its line.
+type timestampParser struct {
+	pattern  *regexp.Regexp
+	group    int /* of pattern holding the timestamp, 0 for the whole match */
+	layout   string
+	location *time.Location
+	field    string /* the event field it is sent as */
+}
+
+// The Go layout and the regexp matching each strftime directive.
+var strftimeDirectives = map[byte][2]string{
+	'Y': {"2006", `\d{4}`},
+	'y': {"06", `\d{2}`},
+	'm': {"1", `\d{1,2}`},
+	'd': {"2", `\d{1,2}`},
+	'e': {"_2", `[ \d]\d`},
+	'H': {"15", `\d{1,2}`},
+	'I': {"3", `\d{1,2}`},
+	'M': {"04", `\d{2}`},
+	'S': {"05", `\d{2}(?:\.\d+)?`}, // Go parses fractional seconds without asking
+	'p': {"PM", `[AP]M`},
+	'b': {"Jan", `[A-Z][a-z]{2}`},
+	'B': {"January", `[A-Z][a-z]+`},
+	'a': {"Mon", `[A-Z][a-z]{2}`},
+	'A': {"Monday", `[A-Z][a-z]+`},
+	'z': {"-0700", `[+-]\d{4}`},
+	'Z': {"MST", `[A-Z]{2,5}`},
+	'T': {"15:04:05", `\d{1,2}:\d{2}:\d{2}(?:\.\d+)?`},
+	'F': {"2006-01-02", `\d{4}-\d{1,2}-\d{1,2}`},
+	'%': {"%", `%`},
+}
+
+// Translates a strftime format into a Go time layout and a regexp matching
+// the times it formats.
+func parseStrftime(format string) (layout string, pattern string, err error) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			layout += format[i : i+1]
+			pattern += regexp.QuoteMeta(format[i : i+1])
+			continue
+		}
+		i++
+		if i == len(format) {
+			return "", "", fmt.Errorf("timestamp format '%s' ends with '%%'", format)
+		}
+		directive, ok := strftimeDirectives[format[i]]
+		if !ok {
+			return "", "", fmt.Errorf("unsupported directive '%%%c' in timestamp format '%s'", format[i], format)
+		}
+		layout += directive[0]
+		pattern += directive[1]
+	}
+	return layout, pattern, nil
+}
+
+// Builds the timestamp parser for a file entry, or nil if it doesn't parse
+// timestamps. The time is found either by a strftime "timestamp format", or
+// by a "timestamp pattern" regexp whose "timestamp" group, first group or
+// whole match is parsed with a Go "timestamp layout".
+func newTimestampParser(fileconfig *FileConfig) (*timestampParser, error) {
+	if fileconfig.TimestampFormat == "" && fileconfig.TimestampLayout == "" {
+		if fileconfig.TimestampPattern != "" {
+			return nil, settingErrorf("timestamp pattern", "a timestamp pattern needs a timestamp layout")
+		}
+		return nil, nil
+	}
+	if fileconfig.TimestampFormat != "" && fileconfig.TimestampLayout != "" {
+		return nil, settingErrorf("timestamp layout", "give either a timestamp format or a timestamp layout, not both")
+	}
+
+	parser := &timestampParser{field: fileconfig.TimestampField, location: time.Local}
+	if parser.field == "" {
+		parser.field = defaultConfig.timestampField
+	}
+
+	pattern := fileconfig.TimestampPattern
+	if fileconfig.TimestampFormat != "" {
+		var derived string
+		var err error
+		parser.layout, derived, err = parseStrftime(fileconfig.TimestampFormat)
+		if err != nil {
+			return nil, settingErrorf("timestamp format", "%s", err)
+		}
+		if pattern == "" {
+			pattern = derived
+		}
+	} else {
+		if pattern == "" {
+			return nil, settingErrorf("timestamp layout", "a timestamp layout needs a timestamp pattern")
+		}
+		parser.layout = fileconfig.TimestampLayout
+	}
+
+	var err error
+	if parser.pattern, err = regexp.Compile(pattern); err != nil {
+		key := "timestamp pattern"
+		if fileconfig.TimestampPattern == "" {
+			key = "timestamp format"
+		}
+		return nil, settingErrorf(key, "invalid timestamp pattern: %s", err)
+	}
+	if index := parser.pattern.SubexpNames(); len(index) > 1 {
+		parser.group = 1
+		for i, name := range index {
+			if name == "timestamp" {
+				parser.group = i
+			}
+		}
+	}
+
+	if fileconfig.Timezone != "" {
+		if parser.location, err = time.LoadLocation(fileconfig.Timezone); err != nil {
+			return nil, settingErrorf("timezone", "invalid timezone: %s", err)
+		}
+	}
+	return parser, nil
+}
+
+// The time in line, if it has one that parses. A time without a year, as
+// in traditional syslog, is taken to be in the year it was read, or the
+// year before if that would put it more than a day into the future.
+func (p *timestampParser) Parse(line string, read time.Time) (time.Time, bool) {
+	match := p.pattern.FindStringSubmatchIndex(line)
+	if match == nil || match[2*p.group] < 0 {
+		return time.Time{}, false
+	}
+	text := strings.TrimSpace(line[match[2*p.group]:match[2*p.group+1]])
+
+	timestamp, err := time.ParseInLocation(p.layout, text, p.location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if timestamp.Year() == 0 {
+		timestamp = timestamp.AddDate(read.In(p.location).Year(), 0, 0)
+		if timestamp.After(read.Add(24 * time.Hour)) {
+			timestamp = timestamp.AddDate(-1, 0, 0)
+		}
+	}
+	return timestamp, true
+}
+
+func formatTimestamp(timestamp time.Time) string {
+	return timestamp.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
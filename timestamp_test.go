@@ -0,0 +1,117 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseStrftime(t *testing.T) {
+	layout, _, err := parseStrftime("%d/%b/%Y:%H:%M:%S %z")
+	chkerr(t, err)
+	if layout != "2/Jan/2006:15:04:05 -0700" {
+		t.Fatalf("Unexpected layout %q", layout)
+	}
+
+	for _, format := range []string{"%Y-%m-%d %Q", "%H:%M:%"} {
+		if _, _, err := parseStrftime(format); err == nil {
+			t.Errorf("Expected an error for format %q", format)
+		}
+	}
+}
+
+func TestTimestampParser(t *testing.T) {
+	read := time.Date(2015, 1, 1, 0, 5, 0, 0, time.UTC)
+	tests := []struct {
+		fileconfig FileConfig
+		line       string
+		expected   string
+	}{
+		{
+			FileConfig{TimestampFormat: "%d/%b/%Y:%H:%M:%S %z"},
+			`127.0.0.1 - - [10/Oct/2014:13:55:36 -0700] "GET / HTTP/1.0" 200 2326`,
+			"2014-10-10T20:55:36.000Z",
+		},
+		{
+			FileConfig{TimestampFormat: "%F %T", Timezone: "UTC"},
+			"2014-10-10 13:55:36.123 INFO started",
+			"2014-10-10T13:55:36.123Z",
+		},
+		{
+			// No year, so it is in the year before it was read
+			FileConfig{TimestampFormat: "%b %e %H:%M:%S", Timezone: "UTC"},
+			"Dec 31 23:59:58 myhost sshd[42]: Accepted publickey",
+			"2014-12-31T23:59:58.000Z",
+		},
+		{
+			FileConfig{TimestampFormat: "%b %e %H:%M:%S", Timezone: "UTC"},
+			"Jan  1 00:04:00 myhost cron[7]: job",
+			"2015-01-01T00:04:00.000Z",
+		},
+		{
+			FileConfig{TimestampPattern: `time=(?P<timestamp>\S+)`, TimestampLayout: time.RFC3339},
+			"level=info time=2014-10-10T13:55:36+02:00 msg=hello",
+			"2014-10-10T11:55:36.000Z",
+		},
+		{
+			FileConfig{TimestampPattern: `^\[(\S+ \S+)\]`, TimestampLayout: "2006-01-02 15:04:05", Timezone: "Europe/Amsterdam"},
+			"[2014-07-01 12:00:00] warning",
+			"2014-07-01T10:00:00.000Z",
+		},
+	}
+
+	for _, test := range tests {
+		parser, err := newTimestampParser(&test.fileconfig)
+		chkerr(t, err)
+		timestamp, ok := parser.Parse(test.line, read)
+		if !ok {
+			t.Errorf("Expected a timestamp in %q", test.line)
+			continue
+		}
+		if formatted := formatTimestamp(timestamp); formatted != test.expected {
+			t.Errorf("Expected %q to give %s, got %s", test.line, test.expected, formatted)
+		}
+	}
+
+	parser, err := newTimestampParser(&FileConfig{TimestampFormat: "%F %T"})
+	chkerr(t, err)
+	if _, ok := parser.Parse("no time here", read); ok {
+		t.Errorf("Expected a line without a timestamp not to parse")
+	}
+	if parser.field != defaultConfig.timestampField {
+		t.Errorf("Expected the default timestamp field, got %q", parser.field)
+	}
+
+	for _, fileconfig := range []FileConfig{
+		{TimestampPattern: `\d+`},
+		{TimestampLayout: time.RFC3339},
+		{TimestampFormat: "%F", TimestampLayout: "2006-01-02"},
+		{TimestampFormat: "%F", Timezone: "Mars/Olympus_Mons"},
+		{TimestampFormat: "%F", TimestampPattern: `(`},
+	} {
+		if _, err := newTimestampParser(&fileconfig); err == nil {
+			t.Errorf("Expected an error for %+v", fileconfig)
+		}
+	}
+}
+
+func TestEventTimestampWithoutSettings(t *testing.T) {
+	// Neither metadata nor timestamp settings, so the time read is sent
+	fileconfig := FileConfig{Paths: []string{"/var/log/a.log"}}
+	chkerr(t, finalizeFileConfig(&fileconfig, &FileConfig{}, nil))
+	h := &Harvester{Path: "/var/log/a.log", FileConfig: fileconfig}
+
+	text := "2014-10-10 13:55:36 INFO started"
+	read := time.Date(2015, 1, 1, 0, 5, 0, 0, time.UTC)
+	found := false
+	for _, pair := range eventPairs(h.event(&text, 1, nil, read, 0)) {
+		if pair.key == defaultConfig.timestampField {
+			found = true
+			if pair.value != "2015-01-01T00:05:00.000Z" {
+				t.Errorf("Expected the time read to be sent, got %s", pair.value)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected a %s field", defaultConfig.timestampField)
+	}
+}